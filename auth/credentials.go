@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"triple-s/utils"
+)
+
+// Credentials maps access key IDs to their secret access keys.
+type Credentials map[string]string
+
+// LoadCredentials reads a credentials file. Each line is a CSV record of the
+// form accessKeyId,secretAccessKey.
+func LoadCredentials(path string) (Credentials, error) {
+	records, err := utils.ReadCSVFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make(Credentials, len(records))
+	for i, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected accessKeyId,secretAccessKey", path, i+1)
+		}
+
+		accessKey := strings.TrimSpace(record[0])
+		secretKey := strings.TrimSpace(record[1])
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("%s:%d: access key ID and secret must not be empty", path, i+1)
+		}
+		if _, ok := credentials[accessKey]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate access key ID %q", path, i+1, accessKey)
+		}
+		credentials[accessKey] = secretKey
+	}
+
+	if len(credentials) == 0 {
+		return nil, errors.New(path + ": no credentials defined")
+	}
+	return credentials, nil
+}
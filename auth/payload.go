@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxChunkSize bounds how much of an aws-chunked body is buffered while
+	// its signature is checked.
+	maxChunkSize = 16 << 20
+	maxChunkLine = 4096
+)
+
+var (
+	ErrMalformedChunk         = errors.New("the aws-chunked request body is malformed")
+	ErrChunkSignatureMismatch = errors.New("the chunk signature we calculated does not match the signature you provided")
+)
+
+// hashingReader fails at the end of the body when its SHA-256 differs from
+// the x-amz-content-sha256 the request was signed with.
+type hashingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected []byte
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(h.hash.Sum(nil), h.expected) {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkSigner verifies the signature chain of a signed aws-chunked body:
+// every chunk is signed together with the signature of the chunk before it,
+// starting from the signature of the request itself.
+type chunkSigner struct {
+	key      []byte
+	amzDate  string
+	scope    Scope
+	previous string
+}
+
+func (s *chunkSigner) verify(data []byte, signature string) bool {
+	stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + s.amzDate + "\n" + s.scope.String() + "\n" +
+		s.previous + "\n" + hashHex(nil) + "\n" + hashHex(data)
+	if !hmac.Equal([]byte(signature), []byte(Sign(s.key, stringToSign))) {
+		return false
+	}
+	s.previous = signature
+	return true
+}
+
+// chunkedReader decodes an aws-chunked body. Each chunk is
+// "<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n"; a zero sized chunk is
+// followed by optional trailer lines and an empty line. With a signer every
+// chunk is verified before any of its data is handed out.
+type chunkedReader struct {
+	r      *bufio.Reader
+	signer *chunkSigner
+	buf    []byte
+	err    error
+}
+
+func newChunkedReader(r io.Reader, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r), signer: signer}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readChunk()
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) readChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return ErrMalformedChunk
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return ErrMalformedChunk
+	}
+
+	if c.signer != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok || !c.signer.verify(data, signature) {
+			return ErrChunkSignatureMismatch
+		}
+	}
+
+	if size == 0 {
+		// Skip trailers up to the terminating empty line.
+		for {
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				return io.EOF
+			}
+		}
+	}
+
+	if end, err := c.readLine(); err != nil || end != "" {
+		return ErrMalformedChunk
+	}
+	c.buf = data
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	var line []byte
+	for {
+		fragment, err := c.r.ReadSlice('\n')
+		line = append(line, fragment...)
+		if len(line) > maxChunkLine {
+			return "", ErrMalformedChunk
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", ErrMalformedChunk
+		}
+		break
+	}
+
+	trimmed, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return "", ErrMalformedChunk
+	}
+	return trimmed, nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PresignRequest describes a presigned URL to mint.
+type PresignRequest struct {
+	Method    string
+	Endpoint  string
+	Bucket    string
+	Key       string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Expires   time.Duration
+}
+
+// Presign returns a URL that performs the described request without any
+// further credentials until it expires. Only the host is signed: the holder
+// may send any body with a PUT and standard headers such as Content-Type,
+// but the server refuses x-amz-* headers like X-Amz-Copy-Source or
+// X-Amz-Acl, which the signature does not cover.
+func Presign(req PresignRequest, now time.Time) (string, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPut {
+		return "", fmt.Errorf("unsupported method %q: only GET and PUT can be presigned", req.Method)
+	}
+	if req.Expires < time.Second || req.Expires > MaxPresignExpiry {
+		return "", fmt.Errorf("expiry must be between 1s and %s", MaxPresignExpiry)
+	}
+	if req.Bucket == "" || req.Key == "" {
+		return "", errors.New("bucket and key are required")
+	}
+
+	endpoint, err := url.Parse(req.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return "", fmt.Errorf("invalid endpoint %q", req.Endpoint)
+	}
+
+	amzDate := now.UTC().Format(TimeFormat)
+	scope := Scope{AccessKey: req.AccessKey, Date: now.UTC().Format(dateFormat), Region: req.Region, Service: "s3"}
+	path := strings.TrimSuffix(endpoint.Path, "/") + "/" + req.Bucket + "/" + req.Key
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", Algorithm)
+	query.Set("X-Amz-Credential", req.AccessKey+"/"+scope.String())
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(req.Expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := CanonicalRequest(req.Method, path, query, nil, endpoint.Host, []string{"host"}, UnsignedPayload)
+	signature := Sign(SigningKey(req.SecretKey, scope), StringToSign(amzDate, scope, canonical))
+
+	return endpoint.Scheme + "://" + endpoint.Host + uriEncode(path, false) + "?" +
+		canonicalQuery(query) + "&X-Amz-Signature=" + signature, nil
+}
@@ -0,0 +1,399 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm = "AWS4-HMAC-SHA256"
+
+	// Values of x-amz-content-sha256 besides the hex digest of the body.
+	UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	TimeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// MaxClockSkew is how far the signing time may be from the server's clock.
+	MaxClockSkew = 15 * time.Minute
+	// MaxPresignExpiry is the longest lifetime a presigned URL may ask for.
+	MaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+var (
+	ErrMissingSignature      = errors.New("request is not signed")
+	ErrMalformedAuth         = errors.New("the authorization header is malformed")
+	ErrMalformedPresign      = errors.New("the query-string authentication parameters are malformed")
+	ErrMissingContentSHA256  = errors.New("signed requests must carry an x-amz-content-sha256 header")
+	ErrInvalidAccessKey      = errors.New("the AWS access key ID you provided does not exist in our records")
+	ErrSignatureMismatch     = errors.New("the request signature we calculated does not match the signature you provided")
+	ErrRequestTimeSkewed     = errors.New("the difference between the request time and the server's time is too large")
+	ErrRequestExpired        = errors.New("request has expired")
+	ErrUnsupportedPayload    = errors.New("the x-amz-content-sha256 value is not supported")
+	ErrContentSHA256Mismatch = errors.New("the provided x-amz-content-sha256 does not match what was computed")
+	ErrUnsignedHeader        = errors.New("there were headers present in the request which were not signed")
+)
+
+// Scope is the credential scope a request was signed for.
+type Scope struct {
+	AccessKey string
+	Date      string
+	Region    string
+	Service   string
+}
+
+func (s Scope) String() string {
+	return s.Date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+}
+
+func parseScope(credential string) (Scope, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || parts[0] == "" {
+		return Scope{}, ErrMalformedAuth
+	}
+	return Scope{AccessKey: parts[0], Date: parts[1], Region: parts[2], Service: parts[3]}, nil
+}
+
+// Verify checks the Signature Version 4 of r, sent either in the
+// Authorization header or as a presigned query string, and returns the
+// access key that signed it. Depending on x-amz-content-sha256 the request
+// body is replaced by a reader that checks the payload hash or decodes and
+// verifies an aws-chunked stream; those readers fail with an error instead of
+// returning io.EOF when the payload does not match.
+func Verify(r *http.Request, credentials Credentials, now time.Time) (string, error) {
+	if r.URL.Query().Has("X-Amz-Algorithm") {
+		return verifyPresigned(r, credentials, now)
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), Algorithm+" ") {
+		return verifyHeader(r, credentials, now)
+	}
+	return "", ErrMissingSignature
+}
+
+func verifyHeader(r *http.Request, credentials Credentials, now time.Time) (string, error) {
+	scope, signedHeaders, signature, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	var signedAt time.Time
+	if amzDate != "" {
+		signedAt, err = time.Parse(TimeFormat, amzDate)
+	} else {
+		signedAt, err = http.ParseTime(r.Header.Get("Date"))
+		amzDate = signedAt.UTC().Format(TimeFormat)
+	}
+	if err != nil || scope.Date != signedAt.UTC().Format(dateFormat) {
+		return "", ErrMalformedAuth
+	}
+	if skew := now.Sub(signedAt); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return "", ErrRequestTimeSkewed
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return "", ErrMissingContentSHA256
+	}
+	if err := checkUnsignedHeaders(r.Header, signedHeaders); err != nil {
+		return "", err
+	}
+
+	secret, ok := credentials[scope.AccessKey]
+	if !ok {
+		return "", ErrInvalidAccessKey
+	}
+
+	key := SigningKey(secret, scope)
+	canonical := canonicalRequest(r, r.URL.Query(), signedHeaders, payloadHash)
+	if !hmac.Equal([]byte(signature), []byte(Sign(key, StringToSign(amzDate, scope, canonical)))) {
+		return "", ErrSignatureMismatch
+	}
+
+	if err := wrapBody(r, payloadHash, key, amzDate, scope, signature); err != nil {
+		return "", err
+	}
+	return scope.AccessKey, nil
+}
+
+func verifyPresigned(r *http.Request, credentials Credentials, now time.Time) (string, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != Algorithm {
+		return "", ErrMalformedPresign
+	}
+
+	scope, err := parseScope(query.Get("X-Amz-Credential"))
+	if err != nil {
+		return "", ErrMalformedPresign
+	}
+
+	amzDate := query.Get("X-Amz-Date")
+	signedAt, err := time.Parse(TimeFormat, amzDate)
+	if err != nil || scope.Date != signedAt.Format(dateFormat) {
+		return "", ErrMalformedPresign
+	}
+
+	seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	expires := time.Duration(seconds) * time.Second
+	if err != nil || expires <= 0 || expires > MaxPresignExpiry {
+		return "", ErrMalformedPresign
+	}
+	if signedAt.Sub(now) > MaxClockSkew {
+		return "", ErrRequestTimeSkewed
+	}
+	if now.After(signedAt.Add(expires)) {
+		return "", ErrRequestExpired
+	}
+
+	signedHeaders := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	signature := query.Get("X-Amz-Signature")
+	if signature == "" || !containsHost(signedHeaders) {
+		return "", ErrMalformedPresign
+	}
+	if err := checkUnsignedHeaders(r.Header, signedHeaders); err != nil {
+		return "", err
+	}
+
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = UnsignedPayload
+	}
+
+	secret, ok := credentials[scope.AccessKey]
+	if !ok {
+		return "", ErrInvalidAccessKey
+	}
+
+	query.Del("X-Amz-Signature")
+	key := SigningKey(secret, scope)
+	canonical := canonicalRequest(r, query, signedHeaders, payloadHash)
+	if !hmac.Equal([]byte(signature), []byte(Sign(key, StringToSign(amzDate, scope, canonical)))) {
+		return "", ErrSignatureMismatch
+	}
+
+	if err := wrapBody(r, payloadHash, key, amzDate, scope, signature); err != nil {
+		return "", err
+	}
+	return scope.AccessKey, nil
+}
+
+// parseAuthorization splits an Authorization header of the form
+// AWS4-HMAC-SHA256 Credential=..., SignedHeaders=..., Signature=...
+func parseAuthorization(header string) (Scope, []string, string, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(header, Algorithm+" "), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return Scope{}, nil, "", ErrMalformedAuth
+		}
+		fields[name] = value
+	}
+
+	scope, err := parseScope(fields["Credential"])
+	if err != nil {
+		return Scope{}, nil, "", err
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	if fields["Signature"] == "" || !containsHost(signedHeaders) {
+		return Scope{}, nil, "", ErrMalformedAuth
+	}
+	return scope, signedHeaders, fields["Signature"], nil
+}
+
+// unsignedAmzHeaders are the x-amz-* headers a request may carry without
+// signing them, as in S3.
+var unsignedAmzHeaders = map[string]bool{
+	"x-amz-content-sha256": true,
+	"x-amz-date":           true,
+	"x-amz-security-token": true,
+}
+
+// checkUnsignedHeaders rejects x-amz-* headers the signature does not
+// cover. They change what a request does, so without this check a presigned
+// PUT could be turned into a copy of any object or given a public ACL.
+func checkUnsignedHeaders(header http.Header, signedHeaders []string) error {
+	signed := make(map[string]bool, len(signedHeaders))
+	for _, name := range signedHeaders {
+		signed[name] = true
+	}
+	for name := range header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") && !signed[name] && !unsignedAmzHeaders[name] {
+			return ErrUnsignedHeader
+		}
+	}
+	return nil
+}
+
+func containsHost(signedHeaders []string) bool {
+	for _, name := range signedHeaders {
+		if name == "host" {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapBody installs the reader that checks the payload against what the
+// signature covered.
+func wrapBody(r *http.Request, payloadHash string, key []byte, amzDate string, scope Scope, seedSignature string) error {
+	switch payloadHash {
+	case UnsignedPayload:
+		return nil
+	case StreamingPayload, StreamingUnsignedTrailer:
+		var signer *chunkSigner
+		if payloadHash == StreamingPayload {
+			signer = &chunkSigner{key: key, amzDate: amzDate, scope: scope, previous: seedSignature}
+		}
+		r.Body = readCloser{newChunkedReader(r.Body, signer), r.Body}
+
+		if decoded, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+			r.ContentLength = decoded
+			r.Header.Set("Content-Length", strconv.FormatInt(decoded, 10))
+		}
+		r.Header.Del("X-Amz-Decoded-Content-Length")
+		stripAWSChunkedEncoding(r.Header)
+		return nil
+	default:
+		expected, err := hex.DecodeString(payloadHash)
+		if err != nil || len(expected) != sha256.Size {
+			return ErrUnsupportedPayload
+		}
+		r.Body = readCloser{&hashingReader{r: r.Body, hash: sha256.New(), expected: expected}, r.Body}
+		return nil
+	}
+}
+
+// stripAWSChunkedEncoding removes the aws-chunked transfer coding from
+// Content-Encoding so it is not stored as the object's own encoding.
+func stripAWSChunkedEncoding(header http.Header) {
+	var kept []string
+	for _, encoding := range strings.Split(header.Get("Content-Encoding"), ",") {
+		if encoding = strings.TrimSpace(encoding); encoding != "" && encoding != "aws-chunked" {
+			kept = append(kept, encoding)
+		}
+	}
+	if len(kept) == 0 {
+		header.Del("Content-Encoding")
+		return
+	}
+	header.Set("Content-Encoding", strings.Join(kept, ","))
+}
+
+// SigningKey derives the key for scope from a secret access key.
+func SigningKey(secret string, scope Scope) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), scope.Date)
+	key = hmacSHA256(key, scope.Region)
+	key = hmacSHA256(key, scope.Service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func StringToSign(amzDate string, scope Scope, canonicalRequest string) string {
+	return Algorithm + "\n" + amzDate + "\n" + scope.String() + "\n" + hashHex([]byte(canonicalRequest))
+}
+
+func Sign(key []byte, stringToSign string) string {
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// CanonicalRequest builds the canonical form of a request for signing. The
+// query is passed separately so that presigning can add its own parameters
+// and verification can leave out X-Amz-Signature.
+func CanonicalRequest(method, path string, query url.Values, header http.Header, host string, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		value := host
+		if name != "host" {
+			value = strings.Join(header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	canonicalURI := uriEncode(path, false)
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	return strings.Join([]string{
+		method,
+		canonicalURI,
+		canonicalQuery(query),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalRequest(r *http.Request, query url.Values, signedHeaders []string, payloadHash string) string {
+	return CanonicalRequest(r.Method, r.URL.Path, query, r.Header, r.Host, signedHeaders, payloadHash)
+}
+
+func canonicalQuery(query url.Values) string {
+	type pair struct{ name, value string }
+	pairs := make([]pair, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, pair{uriEncode(name, true), uriEncode(value, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].name != pairs[j].name {
+			return pairs[i].name < pairs[j].name
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.name + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// uriEncode percent-encodes everything but the RFC 3986 unreserved
+// characters, and the slash unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,152 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newPair writes a fresh self-signed pair to dir and returns the paths and
+// the certificate's serial number.
+func newPair(t *testing.T, dir string) (string, string, *big.Int) {
+	t.Helper()
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if created, err := EnsureSelfSigned(certFile, keyFile); err != nil || !created {
+		t.Fatalf("EnsureSelfSigned: created %v, %v", created, err)
+	}
+	return certFile, keyFile, serialOf(t, certFile)
+}
+
+func serialOf(t *testing.T, certFile string) *big.Int {
+	t.Helper()
+	return parseCertificate(t, certFile).SerialNumber
+}
+
+func parseCertificate(t *testing.T, certFile string) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("%s holds no PEM certificate", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(to, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial completes a TLS handshake with a listener using r and
+// returns the serial number of the certificate it presented.
+func servedSerial(t *testing.T, r *Reloader) *big.Int {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: r.GetCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber
+}
+
+func TestReloaderSwapsCertificate(t *testing.T) {
+	certFile, keyFile, first := newPair(t, t.TempDir())
+	secondCert, secondKey, second := newPair(t, t.TempDir())
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(t, r); got.Cmp(first) != 0 {
+		t.Fatalf("served serial %x, want %x", got, first)
+	}
+
+	// Replacing the files changes nothing until Reload.
+	copyFile(t, secondCert, certFile)
+	copyFile(t, secondKey, keyFile)
+	if got := servedSerial(t, r); got.Cmp(first) != 0 {
+		t.Fatalf("before Reload: served serial %x, want %x", got, first)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(t, r); got.Cmp(second) != 0 {
+		t.Fatalf("after Reload: served serial %x, want %x", got, second)
+	}
+
+	// A broken pair is refused and the loaded one stays in use.
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload accepted a broken certificate")
+	}
+	if got := servedSerial(t, r); got.Cmp(second) != 0 {
+		t.Fatalf("after a failed Reload: served serial %x, want %x", got, second)
+	}
+}
+
+func TestNewReloaderNeedsAPair(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Fatal("NewReloader accepted missing files")
+	}
+
+	certFile, _, _ := newPair(t, dir)
+	_, otherKey, _ := newPair(t, t.TempDir())
+	if _, err := NewReloader(certFile, otherKey); err == nil {
+		t.Fatal("NewReloader accepted a key that does not match the certificate")
+	}
+}
+
+func TestEnsureSelfSignedKeepsExistingFiles(t *testing.T) {
+	certFile, keyFile, serial := newPair(t, t.TempDir())
+	if created, err := EnsureSelfSigned(certFile, keyFile); err != nil || created {
+		t.Fatalf("second EnsureSelfSigned: created %v, %v; want the files kept", created, err)
+	}
+	if got := serialOf(t, certFile); got.Cmp(serial) != 0 {
+		t.Fatalf("got serial %x, want %x", got, serial)
+	}
+
+	leaf := parseCertificate(t, certFile)
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if !leaf.IPAddresses[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("got IP addresses %v, want 127.0.0.1 first", leaf.IPAddresses)
+	}
+}
@@ -0,0 +1,145 @@
+package flag
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the environment variable of every option: -access-log-size
+// is TRIPLES_ACCESS_LOG_SIZE.
+const envPrefix = "TRIPLES_"
+
+// sources records where the value of each option came from, for -print-config.
+var sources = map[string]string{}
+
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// configurable reports whether an option may come from the environment or a
+// config file; the options that choose the configuration itself may not.
+func configurable(name string) bool {
+	return name != "config" && name != "print-config"
+}
+
+type configEntry struct {
+	value string
+	line  int
+}
+
+// applyConfig fills in the options that were not given on the command line,
+// from the environment first and then from the config file.
+func applyConfig(flags *flag.FlagSet) error {
+	onCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+		sources[f.Name] = "command line"
+	})
+
+	path := *ConfigFile
+	if value, ok := os.LookupEnv(envName("config")); ok && !onCommandLine["config"] {
+		path = value
+	}
+
+	var entries map[string]configEntry
+	if path != "" {
+		var err error
+		if entries, err = readConfigFile(path); err != nil {
+			return err
+		}
+		for name, entry := range entries {
+			if flags.Lookup(name) == nil || !configurable(name) {
+				return fmt.Errorf("%s:%d: unknown option %q", path, entry.line, name)
+			}
+		}
+	}
+
+	var errs []error
+	flags.VisitAll(func(f *flag.Flag) {
+		if onCommandLine[f.Name] || !configurable(f.Name) {
+			return
+		}
+
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q: %v", envName(f.Name), value, err))
+			}
+			sources[f.Name] = envName(f.Name)
+		} else if entry, ok := entries[f.Name]; ok {
+			if err := f.Value.Set(entry.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, entry.line, entry.value, f.Name, err))
+			}
+			sources[f.Name] = fmt.Sprintf("%s:%d", path, entry.line)
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// readConfigFile parses a file of "name = value" lines named like the command
+// line options. Blank lines and lines starting with # are ignored. A value
+// may be double-quoted, in which case Go escapes apply and a # comment may
+// follow it.
+func readConfigFile(path string) (map[string]configEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %v", err)
+	}
+	defer file.Close()
+
+	entries := make(map[string]configEntry)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", path, line)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: malformed quoted value", path, line)
+			}
+			if rest := strings.TrimSpace(value[len(quoted):]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, fmt.Errorf("%s:%d: unexpected text after quoted value", path, line)
+			}
+			value, _ = strconv.Unquote(quoted)
+		}
+
+		if previous, ok := entries[name]; ok {
+			return nil, fmt.Errorf("%s:%d: %s is already set on line %d", path, line, name, previous.line)
+		}
+		entries[name] = configEntry{value: value, line: line}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading config file: %v", err)
+	}
+	return entries, nil
+}
+
+// WriteConfig writes the effective configuration in the config file format,
+// noting where each value came from.
+func WriteConfig(w io.Writer) {
+	fmt.Fprintln(w, "# Effective triple-s configuration.")
+	fmt.Fprintln(w, "# Precedence: command line > "+envPrefix+"* environment > config file > default.")
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if !configurable(f.Name) {
+			return
+		}
+		source, ok := sources[f.Name]
+		if !ok {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s = %s # %s\n", f.Name, strconv.Quote(f.Value.String()), source)
+	})
+}
@@ -0,0 +1,168 @@
+package flag
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestFlags defines a few options the way MyFlags does, on their own
+// flag set.
+func newTestFlags() (*flag.FlagSet, map[string]*string) {
+	flags := flag.NewFlagSet("triple-s", flag.ContinueOnError)
+	values := map[string]*string{
+		"port": flags.String("port", "8080", ""),
+		"dir":  flags.String("dir", "data", ""),
+		"tls":  flags.String("tls", "", ""),
+	}
+	ConfigFile = flags.String("config", "", "")
+	flags.Bool("print-config", false, "")
+	return flags, values
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "triple-s.conf")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "# defaults for this host\nport = 9000\ndir = \"from file\" # quoted\ntls = file\n")
+	other := writeConfigFile(t, "port = 7000\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    map[string]string
+		sources map[string]string
+	}{
+		{
+			name:    "defaults",
+			want:    map[string]string{"port": "8080", "dir": "data", "tls": ""},
+			sources: map[string]string{},
+		},
+		{
+			name:    "config file over defaults",
+			args:    []string{"-config", path},
+			want:    map[string]string{"port": "9000", "dir": "from file", "tls": "file"},
+			sources: map[string]string{"config": "command line", "port": path + ":2", "dir": path + ":3", "tls": path + ":4"},
+		},
+		{
+			name:    "environment over config file",
+			args:    []string{"-config", path},
+			env:     map[string]string{"TRIPLES_DIR": "from env", "TRIPLES_TLS": ""},
+			want:    map[string]string{"port": "9000", "dir": "from env", "tls": ""},
+			sources: map[string]string{"config": "command line", "port": path + ":2", "dir": "TRIPLES_DIR", "tls": "TRIPLES_TLS"},
+		},
+		{
+			name:    "command line over environment",
+			args:    []string{"-config", path, "-dir", "from flag", "-port", "1234"},
+			env:     map[string]string{"TRIPLES_DIR": "from env", "TRIPLES_PORT": "4321"},
+			want:    map[string]string{"port": "1234", "dir": "from flag", "tls": "file"},
+			sources: map[string]string{"config": "command line", "port": "command line", "dir": "command line", "tls": path + ":4"},
+		},
+		{
+			name:    "TRIPLES_CONFIG names the file",
+			env:     map[string]string{"TRIPLES_CONFIG": path},
+			want:    map[string]string{"port": "9000", "dir": "from file", "tls": "file"},
+			sources: map[string]string{"port": path + ":2", "dir": path + ":3", "tls": path + ":4"},
+		},
+		{
+			name:    "-config over TRIPLES_CONFIG",
+			args:    []string{"-config", other},
+			env:     map[string]string{"TRIPLES_CONFIG": path},
+			want:    map[string]string{"port": "7000", "dir": "data", "tls": ""},
+			sources: map[string]string{"config": "command line", "port": other + ":1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			sources = map[string]string{}
+			flags, values := newTestFlags()
+			if err := flags.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyConfig(flags); err != nil {
+				t.Fatalf("applyConfig: %v", err)
+			}
+			for name, want := range tc.want {
+				if got := *values[name]; got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if len(sources) != len(tc.sources) {
+				t.Errorf("got sources %v, want %v", sources, tc.sources)
+			}
+			for name, want := range tc.sources {
+				if got := sources[name]; got != want {
+					t.Errorf("source of %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		want   string
+	}{
+		{"unknown option", "colour = blue\n", nil, `:1: unknown option "colour"`},
+		{"option choosing the configuration", "\nprint-config = true\n", nil, `:2: unknown option "print-config"`},
+		{"missing =", "port 9000\n", nil, ":1: expected name = value"},
+		{"set twice", "port = 1\nport = 2\n", nil, ":2: port is already set on line 1"},
+		{"unterminated quote", "dir = \"data\n", nil, ":1: malformed quoted value"},
+		{"text after quote", "dir = \"data\" more\n", nil, ":1: unexpected text after quoted value"},
+		{"invalid value in the environment", "", map[string]string{"TRIPLES_PRINT_CONFIG": "yes", "TRIPLES_VERBOSE": "maybe"}, `TRIPLES_VERBOSE: invalid value "maybe"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			sources = map[string]string{}
+			flags, _ := newTestFlags()
+			flags.Bool("verbose", false, "")
+			if err := flags.Parse([]string{"-config", writeConfigFile(t, tc.config)}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyConfig(flags)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileQuoting(t *testing.T) {
+	path := writeConfigFile(t, "a = plain value # not a comment\nb = \"tab\\tand # hash\" # comment\n  c=  \"\"  \n")
+	entries, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a": "plain value # not a comment", "b": "tab\tand # hash", "c": ""}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for name, value := range want {
+		if entries[name].value != value {
+			t.Errorf("%s = %q, want %q", name, entries[name].value, value)
+		}
+	}
+}
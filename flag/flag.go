@@ -6,21 +6,90 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
-	Address *string
-	Dir     *string
+	Address     *string
+	Listen      []string
+	Dir         *string
+	Credentials *string
+
+	AccessLogSize    *int
+	AccessLogBackups *int
+
+	ReadTimeout     *time.Duration
+	WriteTimeout    *time.Duration
+	IdleTimeout     *time.Duration
+	ShutdownTimeout *time.Duration
+
+	TLSCert       *string
+	TLSKey        *string
+	TLSSelfSigned *bool
+	TLSClientCA   *string
+
+	MetricsListen *string
+
+	ConfigFile  *string
+	PrintConfig *bool
 )
 
+var listen listenAddresses
+
 var restrictedDirs = []string{"go.mod", "flag", "handlers", "utils", "triple-s"}
 
 func MyFlags() error {
 	Address = flag.String("port", "8080", "HTTP network address")
+	flag.Var(&listen, "listen", "host:port, [ipv6]:port or unix:/path to serve on; may be repeated")
 	Dir = flag.String("dir", "data", "base dir")
+	Credentials = flag.String("credentials", "", "credentials file enabling SigV4 authentication; unsigned requests become anonymous")
+	AccessLogSize = flag.Int("access-log-size", 64, "size in MiB at which the access log is rotated")
+	AccessLogBackups = flag.Int("access-log-backups", 5, "number of rotated access logs to keep")
+	ReadTimeout = flag.Duration("read-timeout", 15*time.Minute, "longest time to read a request, body included")
+	WriteTimeout = flag.Duration("write-timeout", 15*time.Minute, "longest time to write a response")
+	IdleTimeout = flag.Duration("idle-timeout", 2*time.Minute, "how long an idle keep-alive connection stays open")
+	ShutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long shutdown waits for in-flight requests")
+	TLSCert = flag.String("tls-cert", "", "PEM certificate file; serves HTTPS and HTTP/2")
+	TLSKey = flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	TLSSelfSigned = flag.Bool("tls-self-signed", false, "generate a self-signed certificate if the files do not exist")
+	TLSClientCA = flag.String("tls-client-ca", "", "PEM CA certificates that client certificates must chain to")
+	MetricsListen = flag.String("metrics-listen", "", "host:port or unix:/path to serve Prometheus metrics on, without authentication")
+	ConfigFile = flag.String("config", "", "config file of name = value lines")
+	PrintConfig = flag.Bool("print-config", false, "print the effective configuration and exit")
 	flag.Usage = usage
 	flag.Parse()
 
+	if err := applyConfig(flag.CommandLine); err != nil {
+		return err
+	}
+
+	if len(listen) > 0 && sources["port"] != "" {
+		return fmt.Errorf("-port and -listen cannot be used together")
+	}
+	Listen = listen
+	if len(Listen) == 0 {
+		if err := checkListenAddress(":" + *Address); err != nil {
+			return fmt.Errorf("-port %q is not a valid port", *Address)
+		}
+		Listen = []string{":" + *Address}
+	}
+
+	if *MetricsListen != "" {
+		if err := checkListenAddress(*MetricsListen); err != nil {
+			return fmt.Errorf("-metrics-listen: %v", err)
+		}
+	}
+
+	if *AccessLogSize < 1 {
+		return fmt.Errorf("-access-log-size must be at least 1")
+	}
+	if *AccessLogBackups < 0 {
+		return fmt.Errorf("-access-log-backups must not be negative")
+	}
+	if *ReadTimeout < 0 || *WriteTimeout < 0 || *IdleTimeout < 0 || *ShutdownTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+
 	cleanedDir := filepath.Clean(*Dir)
 
 	for _, res := range restrictedDirs {
@@ -42,6 +111,17 @@ func MyFlags() error {
 		return fmt.Errorf("'%s' exists as a file and cannot be used as a directory", *Dir)
 	}
 
+	if (*TLSCert == "") != (*TLSKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if *TLSSelfSigned && *TLSCert == "" {
+		*TLSCert = filepath.Join(cleanedDir, ".tls", "cert.pem")
+		*TLSKey = filepath.Join(cleanedDir, ".tls", "key.pem")
+	}
+	if *TLSClientCA != "" && *TLSCert == "" {
+		return fmt.Errorf("-tls-client-ca requires TLS")
+	}
+
 	return nil
 }
 
@@ -49,11 +129,112 @@ func usage() {
 	fmt.Println(`Simple Storage Service.
 
 **Usage:**
-    triple-s [-port <N>] [-dir <S>]  
+    triple-s [-config <F>] [-port <N> | -listen <A>...] [-dir <S>] [-credentials <F>] [options]
+    triple-s presign -bucket <B> -key <K> -credentials <F> [options]
     triple-s --help
 
 **Options:**
 - --help     Show this screen.
-- --port N   Port number
-- --dir S    Path to the directory`)
+- --config F Read options from F, one "name = value" per line, e.g.
+             port = 9000. Each option can also be set with a TRIPLES_*
+             environment variable, e.g. TRIPLES_ACCESS_LOG_SIZE=128.
+             Command line options win over the environment, which wins
+             over the config file. TRIPLES_CONFIG names the config file.
+- --print-config
+             Print the effective configuration and where each value came
+             from, then exit.
+- --port N   Port number, served on every interface
+- --listen A Serve on A instead of -port: host:port, [ipv6]:port or
+             unix:/path for a Unix socket. Repeat the option, or separate
+             addresses with commas, to serve on several at once.
+- --dir S    Path to the directory
+- --credentials F
+             CSV file of accessKeyId,secretAccessKey pairs. When set, signed
+             requests must carry a valid AWS Signature Version 4, and
+             unsigned requests are anonymous: they are denied unless a
+             bucket policy or a public-read or public-read-write ACL
+             grants them access.
+- --access-log-size M
+             Rotate <dir>/.logs/access.log once it reaches M MiB (default 64)
+- --access-log-backups K
+             Number of rotated access logs to keep (default 5)
+- --read-timeout D
+             Longest time to read a request, body included (default 15m, 0 for none)
+- --write-timeout D
+             Longest time to write a response (default 15m, 0 for none)
+- --idle-timeout D
+             How long idle keep-alive connections stay open (default 2m)
+- --shutdown-timeout D
+             How long SIGINT or SIGTERM waits for in-flight requests before
+             closing their connections (default 30s)
+- --tls-cert F, --tls-key F
+             Serve HTTPS, and HTTP/2, with this PEM certificate and key.
+             SIGHUP reloads both files.
+- --tls-self-signed
+             Generate a self-signed certificate for local development when
+             the files do not exist yet; without -tls-cert it is kept in
+             <dir>/.tls.
+- --tls-client-ca F
+             Require client certificates signed by the PEM CAs in F.
+- --metrics-listen A
+             Serve request, connection and storage metrics in the
+             Prometheus text format at http://A/metrics, an address of the
+             same form as -listen. Off by default. Scrapes are not
+             authenticated and list every bucket name, so bind A to an
+             address only the monitoring system can reach.
+
+Run 'triple-s presign --help' for the presign options.`)
+}
+
+func presignUsage() {
+	fmt.Println(`Print a presigned URL for an object.
+
+**Usage:**
+    triple-s presign -bucket <B> -key <K> -credentials <F> [options]
+
+**Options:**
+- --method M       GET (default) or PUT
+- --endpoint U     Base URL of the server (default http://localhost:8080)
+- --bucket B       Bucket name
+- --key K          Object key
+- --credentials F  Credentials file to sign with
+- --access-key A   Access key ID to use when the file holds several
+- --region R       Region in the credential scope (default us-east-1)
+- --expires D      Lifetime of the URL, e.g. 15m or 24h (default 1h, at most 168h)`)
+}
+
+// PresignOptions are the arguments of the presign command.
+type PresignOptions struct {
+	Method      string
+	Endpoint    string
+	Bucket      string
+	Key         string
+	Credentials string
+	AccessKey   string
+	Region      string
+	Expires     time.Duration
+}
+
+func PresignFlags(args []string) (PresignOptions, error) {
+	var options PresignOptions
+
+	flags := flag.NewFlagSet("presign", flag.ContinueOnError)
+	flags.StringVar(&options.Method, "method", "GET", "HTTP method the URL allows, GET or PUT")
+	flags.StringVar(&options.Endpoint, "endpoint", "http://localhost:8080", "base URL of the server")
+	flags.StringVar(&options.Bucket, "bucket", "", "bucket name")
+	flags.StringVar(&options.Key, "key", "", "object key")
+	flags.StringVar(&options.Credentials, "credentials", "", "credentials file to sign with")
+	flags.StringVar(&options.AccessKey, "access-key", "", "access key ID to sign with, if the file holds several")
+	flags.StringVar(&options.Region, "region", "us-east-1", "region in the credential scope")
+	flags.DurationVar(&options.Expires, "expires", time.Hour, "how long the URL stays valid, at most 168h")
+	flags.Usage = presignUsage
+	if err := flags.Parse(args); err != nil {
+		return PresignOptions{}, err
+	}
+
+	options.Method = strings.ToUpper(options.Method)
+	if options.Bucket == "" || options.Key == "" || options.Credentials == "" {
+		return PresignOptions{}, fmt.Errorf("-bucket, -key and -credentials are required")
+	}
+	return options, nil
 }
@@ -0,0 +1,58 @@
+package flag
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks a -listen address as the path of a Unix socket.
+const unixPrefix = "unix:"
+
+// listenAddresses collects -listen. The flag may be repeated, and one value
+// may hold several comma-separated addresses so that the environment and the
+// config file can give more than one.
+type listenAddresses []string
+
+func (l *listenAddresses) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenAddresses) Set(value string) error {
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if err := checkListenAddress(address); err != nil {
+			return err
+		}
+		*l = append(*l, address)
+	}
+	return nil
+}
+
+func checkListenAddress(address string) error {
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("%q has no socket path", address)
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%q is not host:port, [ipv6]:port or unix:/path", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%q has an invalid port", address)
+	}
+	return nil
+}
+
+// ListenNetwork splits a -listen address into the network and address that
+// net.Listen takes.
+func ListenNetwork(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", address
+}
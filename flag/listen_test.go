@@ -0,0 +1,66 @@
+package flag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListenAddresses(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+		ok     bool
+	}{
+		{[]string{"127.0.0.1:9000"}, []string{"127.0.0.1:9000"}, true},
+		{[]string{":9000"}, []string{":9000"}, true},
+		{[]string{"localhost:0"}, []string{"localhost:0"}, true},
+		{[]string{"[::1]:9000"}, []string{"[::1]:9000"}, true},
+		{[]string{"unix:/run/triple-s.sock"}, []string{"unix:/run/triple-s.sock"}, true},
+		{[]string{"127.0.0.1:9000", "unix:/run/s.sock"}, []string{"127.0.0.1:9000", "unix:/run/s.sock"}, true},
+		{[]string{"127.0.0.1:9000, [::1]:9000,unix:s.sock"}, []string{"127.0.0.1:9000", "[::1]:9000", "unix:s.sock"}, true},
+		{[]string{"9000"}, nil, false},
+		{[]string{"::1:9000"}, nil, false},
+		{[]string{"localhost:http"}, nil, false},
+		{[]string{"localhost:65536"}, nil, false},
+		{[]string{"localhost:-1"}, nil, false},
+		{[]string{"unix:"}, nil, false},
+		{[]string{"127.0.0.1:9000,"}, nil, false},
+	}
+
+	for _, tc := range tests {
+		var listen listenAddresses
+		var err error
+		for _, value := range tc.values {
+			if err = listen.Set(value); err != nil {
+				break
+			}
+		}
+
+		if (err == nil) != tc.ok {
+			t.Errorf("-listen %q: got error %v, want ok %v", tc.values, err, tc.ok)
+			continue
+		}
+		if tc.ok && !reflect.DeepEqual([]string(listen), tc.want) {
+			t.Errorf("-listen %q: got %q, want %q", tc.values, listen, tc.want)
+		}
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	tests := []struct {
+		address, network, addr string
+	}{
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"[::1]:9000", "tcp", "[::1]:9000"},
+		{":9000", "tcp", ":9000"},
+		{"unix:/run/triple-s.sock", "unix", "/run/triple-s.sock"},
+		{"unix:relative.sock", "unix", "relative.sock"},
+	}
+
+	for _, tc := range tests {
+		network, addr := ListenNetwork(tc.address)
+		if network != tc.network || addr != tc.addr {
+			t.Errorf("ListenNetwork(%q) = %q, %q; want %q, %q", tc.address, network, addr, tc.network, tc.addr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+	"triple-s/store"
+)
+
+func (b *BucketHandler) GetBucketACL(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	bucket, err := b.Store.GetBucket(bucketName)
+	if errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(accessControlPolicy(bucket.ACL))
+}
+
+// PutBucketACL takes a canned ACL from the x-amz-acl header, or an access
+// control policy whose grants match one of them.
+func (b *BucketHandler) PutBucketACL(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	acl, err := aclFromRequest(r)
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
+		return
+	}
+
+	if r.Header.Get("X-Amz-Acl") == "" {
+		// Clients may or may not send the S3 namespace, and the grantee
+		// type is an xsi attribute, so the body has its own shape.
+		var body struct {
+			XMLName xml.Name `xml:"AccessControlPolicy"`
+			Grants  []struct {
+				Grantee struct {
+					Type string `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+					ID   string `xml:"ID"`
+					URI  string `xml:"URI"`
+				} `xml:"Grantee"`
+				Permission string `xml:"Permission"`
+			} `xml:"AccessControlList>Grant"`
+		}
+		if !decodeXMLBody(w, r, 1<<20, &body, ErrMalformedACL) {
+			return
+		}
+
+		grants := make([]Grant, 0, len(body.Grants))
+		for _, grant := range body.Grants {
+			grants = append(grants, Grant{
+				Grantee:    Grantee{Type: grant.Grantee.Type, ID: grant.Grantee.ID, URI: grant.Grantee.URI},
+				Permission: grant.Permission,
+			})
+		}
+
+		var ok bool
+		if acl, ok = cannedACLFromGrants(grants); !ok {
+			WriteXMLError(w, r, ErrNotImplemented.WithMessage("Only grants matching a canned ACL are supported"))
+			return
+		}
+	}
+
+	if !b.updateBucketConfig(w, r, bucketName, func(bucket *store.Bucket) {
+		bucket.ACL = acl
+	}) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *ObjectHandler) GetObjectACL(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	if !o.checkBucket(w, r, bucketName) {
+		return
+	}
+
+	object, _, err := lookupVersion(o.Store, o.BaseDir, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if err != nil {
+		WriteXMLError(w, r, versionLookupError(w, object, err))
+		return
+	}
+
+	setVersionHeader(w, object)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(accessControlPolicy(object.ACL))
+}
+
+// PutObjectACL is not supported: an object's ACL is chosen with x-amz-acl
+// when the object is written.
+func (o *ObjectHandler) PutObjectACL(w http.ResponseWriter, r *http.Request) {
+	WriteXMLError(w, r, ErrNotImplemented.WithMessage("Object ACLs can only be set when the object is written"))
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"triple-s/policy"
+)
+
+const (
+	xsiNamespace          = "http://www.w3.org/2001/XMLSchema-instance"
+	allUsersURI           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersURI = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// aclOwner owns every bucket and object; all credentials act on its behalf.
+var aclOwner = Owner{ID: "triple-s", DisplayName: "triple-s"}
+
+var cannedACLs = []string{
+	policy.ACLPrivate,
+	policy.ACLPublicRead,
+	policy.ACLPublicReadWrite,
+	policy.ACLAuthenticatedRead,
+}
+
+// aclFromRequest returns the canned ACL named by the x-amz-acl header,
+// private when there is none.
+func aclFromRequest(r *http.Request) (string, error) {
+	acl := r.Header.Get("X-Amz-Acl")
+	if acl == "" {
+		return policy.ACLPrivate, nil
+	}
+	if !policy.ValidACL(acl) {
+		return "", fmt.Errorf("unsupported canned ACL %q", acl)
+	}
+	return acl, nil
+}
+
+// accessControlPolicy spells out the grants of a canned ACL.
+func accessControlPolicy(acl string) AccessControlPolicy {
+	grants := []Grant{{
+		Grantee:    Grantee{XMLNSXSI: xsiNamespace, Type: "CanonicalUser", ID: aclOwner.ID, DisplayName: aclOwner.DisplayName},
+		Permission: "FULL_CONTROL",
+	}}
+	group := func(uri, permission string) Grant {
+		return Grant{Grantee: Grantee{XMLNSXSI: xsiNamespace, Type: "Group", URI: uri}, Permission: permission}
+	}
+
+	switch acl {
+	case policy.ACLPublicRead:
+		grants = append(grants, group(allUsersURI, "READ"))
+	case policy.ACLPublicReadWrite:
+		grants = append(grants, group(allUsersURI, "READ"), group(allUsersURI, "WRITE"))
+	case policy.ACLAuthenticatedRead:
+		grants = append(grants, group(authenticatedUsersURI, "READ"))
+	}
+	return AccessControlPolicy{Owner: aclOwner, Grants: grants}
+}
+
+// cannedACLFromGrants finds the canned ACL with exactly the given grants.
+// Grants to the owner are implied and ignored.
+func cannedACLFromGrants(grants []Grant) (string, bool) {
+	want := grantSet(grants)
+	for _, acl := range cannedACLs {
+		if grantSet(accessControlPolicy(acl).Grants) == want {
+			return acl, true
+		}
+	}
+	return "", false
+}
+
+func grantSet(grants []Grant) string {
+	var entries []string
+	for _, grant := range grants {
+		if grant.Grantee.Type == "CanonicalUser" && grant.Grantee.ID == aclOwner.ID {
+			continue
+		}
+		entries = append(entries, grant.Grantee.Type+" "+grant.Grantee.ID+grant.Grantee.URI+" "+grant.Permission)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
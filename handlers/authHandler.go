@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+	"triple-s/auth"
+)
+
+// AuthHandler checks the AWS Signature Version 4 of every signed request
+// before handing it to Next.
+type AuthHandler struct {
+	Credentials auth.Credentials
+	Next        http.Handler
+}
+
+type principalKey struct{}
+
+// ServeHTTP passes unsigned requests on as anonymous; whether they may do
+// anything is up to the bucket's policy.
+func (a *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accessKey, err := auth.Verify(r, a.Credentials, time.Now())
+	if err != nil && !errors.Is(err, auth.ErrMissingSignature) {
+		WriteXMLError(w, r, authError(err))
+		return
+	}
+	a.Next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, accessKey)))
+}
+
+// requestPrincipal returns the access key that signed r. anonymous is set
+// for unsigned requests to a server that checks signatures; a server without
+// credentials trusts every request.
+func requestPrincipal(r *http.Request) (accessKey string, anonymous bool) {
+	accessKey, checked := r.Context().Value(principalKey{}).(string)
+	return accessKey, checked && accessKey == ""
+}
+
+// authError maps a signature verification failure to its S3 error.
+func authError(err error) APIError {
+	var apiErr APIError
+	switch {
+	case errors.Is(err, auth.ErrMalformedAuth):
+		apiErr = ErrAuthorizationHeaderMalformed
+	case errors.Is(err, auth.ErrMalformedPresign):
+		apiErr = ErrAuthorizationQueryParametersError
+	case errors.Is(err, auth.ErrMissingContentSHA256), errors.Is(err, auth.ErrUnsupportedPayload):
+		apiErr = ErrInvalidArgument
+	case errors.Is(err, auth.ErrInvalidAccessKey):
+		apiErr = ErrInvalidAccessKeyID
+	case errors.Is(err, auth.ErrSignatureMismatch), errors.Is(err, auth.ErrChunkSignatureMismatch):
+		apiErr = ErrSignatureDoesNotMatch
+	case errors.Is(err, auth.ErrRequestTimeSkewed):
+		apiErr = ErrRequestTimeTooSkewed
+	case errors.Is(err, auth.ErrContentSHA256Mismatch):
+		apiErr = ErrXAmzContentSHA256Mismatch
+	case errors.Is(err, auth.ErrMalformedChunk):
+		apiErr = ErrIncompleteBody
+	default:
+		apiErr = ErrAccessDenied
+	}
+	return apiErr.WithMessage(err.Error())
+}
+
+// writeBodyError reports a failure to store a request body. Payloads that
+// are too large or do not match their signature are the client's fault;
+// anything else is ours.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		WriteXMLError(w, r, ErrEntityTooLarge)
+	case errors.Is(err, auth.ErrContentSHA256Mismatch), errors.Is(err, auth.ErrMalformedChunk),
+		errors.Is(err, auth.ErrChunkSignatureMismatch):
+		WriteXMLError(w, r, authError(err))
+	default:
+		WriteXMLError(w, r, ErrInternalError.WithMessage(message))
+	}
+}
+
+// decodeXMLBody decodes a request body of at most limit bytes into v. The
+// body is read to the end first: a decoder stops after the root element, and
+// the payload hash auth checks against x-amz-content-sha256 is only compared
+// at EOF. malformed is the error for a body that is too large or does not
+// parse. On failure an error response has already been written.
+func decodeXMLBody(w http.ResponseWriter, r *http.Request, limit int64, v any, malformed APIError) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		writeBodyError(w, r, err, "Failed to read request body")
+		return false
+	}
+	if int64(len(body)) > limit {
+		WriteXMLError(w, r, malformed.WithMessage("Request body is too large"))
+		return false
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		WriteXMLError(w, r, malformed)
+		return false
+	}
+	return true
+}
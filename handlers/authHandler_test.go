@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"triple-s/auth"
+)
+
+const testSecretKey = "secretkey"
+
+func presignedPut(t *testing.T, key string) string {
+	t.Helper()
+
+	url, err := auth.Presign(auth.PresignRequest{
+		Method:    http.MethodPut,
+		Endpoint:  "http://example.com",
+		Bucket:    testBucket,
+		Key:       key,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+		Region:    "us-east-1",
+		Expires:   time.Hour,
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url
+}
+
+// A presigned URL signs only the host, so headers that would change what the
+// request does must be refused rather than run with the signer's rights.
+func TestPresignedPutRejectsUnsignedAmzHeaders(t *testing.T) {
+	inner := newTestServer(t)
+	h := &AuthHandler{Credentials: auth.Credentials{testAccessKey: testSecretKey}, Next: inner}
+	createBucket(t, inner)
+	expectStatus(t, do(t, inner, http.MethodPut, "/"+testBucket+"/secret", "secret"), http.StatusOK, "PUT secret")
+
+	for _, header := range [][]string{
+		{"X-Amz-Copy-Source", testBucket + "/secret"},
+		{"X-Amz-Acl", "public-read"},
+	} {
+		r := newRequest(http.MethodPut, presignedPut(t, "upload"), "data", header...)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		expectStatus(t, w, http.StatusForbidden, "presigned PUT with "+header[0])
+		if !strings.Contains(w.Body.String(), "AccessDenied") {
+			t.Fatalf("presigned PUT with %s: got %s, want AccessDenied", header[0], w.Body.String())
+		}
+	}
+	expectStatus(t, do(t, inner, http.MethodGet, "/"+testBucket+"/upload", ""), http.StatusNotFound, "GET upload")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newRequest(http.MethodPut, presignedPut(t, "upload"), "data", "Content-Type", "text/plain"))
+	expectStatus(t, w, http.StatusOK, "presigned PUT")
+	expectObject(t, inner, "upload", "data")
+}
+
+// signRequest signs r with testAccessKey in the Authorization header,
+// declaring payloadHash as the hash of its body.
+func signRequest(r *http.Request, payloadHash string) {
+	now := time.Now().UTC()
+	amzDate := now.Format(auth.TimeFormat)
+	scope := auth.Scope{AccessKey: testAccessKey, Date: now.Format("20060102"), Region: "us-east-1", Service: "s3"}
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonical := auth.CanonicalRequest(r.Method, r.URL.Path, r.URL.Query(), r.Header, r.Host, signedHeaders, payloadHash)
+	signature := auth.Sign(auth.SigningKey(testSecretKey, scope), auth.StringToSign(amzDate, scope, canonical))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		auth.Algorithm, testAccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func payloadHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// XML bodies are decoded only up to their root element; the payload hash
+// must still be checked before they take effect.
+func TestXMLBodyMustMatchSignedPayloadHash(t *testing.T) {
+	inner := newTestServer(t)
+	h := &AuthHandler{Credentials: auth.Credentials{testAccessKey: testSecretKey}, Next: inner}
+	createBucket(t, inner)
+
+	publicRead := `<AccessControlPolicy><AccessControlList><Grant>` +
+		`<Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group"><URI>` + allUsersURI + `</URI></Grantee>` +
+		`<Permission>READ</Permission></Grant></AccessControlList></AccessControlPolicy>`
+	for _, tc := range []struct {
+		target, body, check, applied string
+	}{
+		{"?versioning", `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`, "?versioning", "Enabled"},
+		{"?acl", publicRead, "?acl", allUsersURI},
+	} {
+		signed := payloadHash("something else")
+		r := newRequest(http.MethodPut, "/"+testBucket+tc.target, tc.body)
+		signRequest(r, signed)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		expectStatus(t, w, http.StatusBadRequest, "PUT "+tc.target+" with a mismatched body")
+		if !strings.Contains(w.Body.String(), "XAmzContentSHA256Mismatch") {
+			t.Fatalf("PUT %s: got %s, want XAmzContentSHA256Mismatch", tc.target, w.Body.String())
+		}
+		if got := do(t, inner, http.MethodGet, "/"+testBucket+tc.check, "").Body.String(); strings.Contains(got, tc.applied) {
+			t.Fatalf("PUT %s with a mismatched body took effect: %s", tc.target, got)
+		}
+
+		r = newRequest(http.MethodPut, "/"+testBucket+tc.target, tc.body)
+		signRequest(r, payloadHash(tc.body))
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		expectStatus(t, w, http.StatusOK, "PUT "+tc.target)
+		if got := do(t, inner, http.MethodGet, "/"+testBucket+tc.check, "").Body.String(); !strings.Contains(got, tc.applied) {
+			t.Fatalf("PUT %s did not take effect: %s", tc.target, got)
+		}
+	}
+}
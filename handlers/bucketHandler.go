@@ -2,85 +2,99 @@ package handlers
 
 import (
 	"encoding/xml"
+	"errors"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"triple-s/store"
 	"triple-s/utils"
 )
 
 func (b *BucketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		b.ListBuckets(w, r)
-	case http.MethodPut:
-		b.CreateBucket(w, r)
-	case http.MethodDelete:
-		b.DeleteBucket(w, r)
-	default:
-		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	rt, ok := classify(r)
+	if !ok || rt.bucket == nil {
+		WriteXMLError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if action := rt.actionFor(r); action != "" {
+		if !authorize(b.Store, w, r, strings.Trim(r.URL.Path, "/"), "", "", action) {
+			return
+		}
 	}
+	rt.bucket(b, w, r)
 }
 
 func (b *BucketHandler) CreateBucket(w http.ResponseWriter, r *http.Request) {
 	if err := utils.EnsureDirExists(b.BaseDir); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to create base directory")
-		return
-	}
-
-	csvPath := path.Join(b.BaseDir, "buckets.csv")
-	if err := utils.EnsureFileExists(csvPath); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to create buckets.csv")
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to create base directory"))
 		return
 	}
 
 	bucketName := r.URL.Path[1:]
 
 	if err := utils.ValidateBucketName(bucketName); err != nil {
-		WriteXMLError(w, http.StatusBadRequest, err.Error())
+		WriteXMLError(w, r, ErrInvalidBucketName.WithMessage(err.Error()))
+		return
+	}
+
+	acl, err := aclFromRequest(r)
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
 		return
 	}
 
-	unique, err := utils.CheckBucketUniqueness(bucketName, csvPath)
+	unlock, err := b.Locks.LockBucket(bucketName)
 	if err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, err.Error())
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock bucket"))
 		return
 	}
+	defer unlock()
 
-	if !unique {
-		WriteXMLError(w, http.StatusConflict, "Bucket name already exists")
+	if _, err := b.Store.GetBucket(bucketName); err == nil {
+		WriteXMLError(w, r, ErrBucketAlreadyExists)
+		return
+	} else if !errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
 		return
 	}
 
 	bucketPath := filepath.Join(b.BaseDir, bucketName)
 	if _, err := os.Stat(bucketPath); !os.IsNotExist(err) {
-		WriteXMLError(w, http.StatusConflict, "Bucket already exists")
+		WriteXMLError(w, r, ErrBucketAlreadyExists)
 		return
 	}
 
 	if err := os.Mkdir(bucketPath, os.ModePerm); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to create bucket")
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to create bucket"))
 		return
 	}
 
 	now := time.Now()
-	bucket := Bucket{
+	bucket := store.Bucket{
 		Name:             bucketName,
 		CreationTime:     now,
 		LastModifiedTime: now,
 		Status:           "marked for deletion",
+		ACL:              acl,
 	}
 
-	if err := b.appendBucketMetadata(bucket); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to update metadata")
+	if err := b.createBucketMetadata(bucket); err != nil {
+		os.RemoveAll(bucketPath)
+		if errors.Is(err, store.ErrBucketExists) {
+			WriteXMLError(w, r, ErrBucketAlreadyExists)
+			return
+		}
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update metadata"))
 		return
 	}
 	response := struct {
 		XMLName xml.Name `xml:"CreateBucketResponse"`
 		Bucket  Bucket   `xml:"Bucket"`
 	}{
-		Bucket: bucket,
+		Bucket: bucketFromRecord(bucket),
 	}
 
 	w.Header().Set("Content-Type", "application/xml")
@@ -88,40 +102,20 @@ func (b *BucketHandler) CreateBucket(w http.ResponseWriter, r *http.Request) {
 }
 
 func (b *BucketHandler) ListBuckets(w http.ResponseWriter, r *http.Request) {
-	csvPath := path.Join(b.BaseDir, "buckets.csv")
-
 	if r.URL.Path != "/" {
-		WriteXMLError(w, http.StatusBadRequest, "List buckets only available at root path")
+		WriteXMLError(w, r, ErrInvalidRequest.WithMessage("List buckets only available at root path"))
 		return
 	}
 
-	records, err := utils.ReadCSVFile(csvPath)
+	records, err := b.Store.ListBuckets()
 	if err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to read metadata")
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read metadata"))
 		return
 	}
 
 	var buckets []Bucket
 	for _, record := range records {
-		createdTime, err := time.Parse(time.RFC3339, record[1])
-		if err != nil {
-			WriteXMLError(w, http.StatusInternalServerError, "Invalid creation time format in CSV")
-			return
-		}
-		lastModifiedTime, err := time.Parse(time.RFC3339, record[2])
-		if err != nil {
-			WriteXMLError(w, http.StatusInternalServerError, "Invalid last modified time format in CSV")
-			return
-		}
-
-		bucket := Bucket{
-			Name:             record[0],
-			CreationTime:     createdTime,
-			LastModifiedTime: lastModifiedTime,
-			Status:           record[3],
-		}
-
-		buckets = append(buckets, bucket)
+		buckets = append(buckets, bucketFromRecord(record))
 	}
 
 	response := struct {
@@ -136,61 +130,182 @@ func (b *BucketHandler) ListBuckets(w http.ResponseWriter, r *http.Request) {
 	xml.NewEncoder(w).Encode(response)
 }
 
+func (b *BucketHandler) ListObjects(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	if _, err := b.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+
+	query := r.URL.Query()
+	if listType := query.Get("list-type"); listType != "" && listType != "2" {
+		WriteXMLError(w, r, ErrNotImplemented.WithMessage("Only list-type=2 is supported"))
+		return
+	}
+
+	params := listObjectsParams{
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxListKeys,
+	}
+
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			WriteXMLError(w, r, ErrInvalidArgument.WithMessage("max-keys must be a non-negative integer"))
+			return
+		}
+		params.MaxKeys = min(n, maxListKeys)
+	}
+
+	objects, err := b.Store.ListObjects(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+		return
+	}
+
+	response, err := listObjectsPage(bucketName, objects, params)
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (b *BucketHandler) HeadBucket(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	if _, err := b.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (b *BucketHandler) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	bucketName := r.URL.Path[1:]
 	if bucketName == "" || bucketName == "/" {
-		WriteXMLError(w, http.StatusBadRequest, "Bucket name not specified")
+		WriteXMLError(w, r, ErrInvalidBucketName.WithMessage("Bucket name not specified"))
 		return
 	}
 
-	csvPath := filepath.Join(b.BaseDir, "buckets.csv")
-	records, err := utils.ReadCSVFile(csvPath)
-	if err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to read bucket metadata")
+	if _, err := b.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
 		return
 	}
 
-	bucketExists := false
-	for _, record := range records {
-		if record[0] == bucketName {
-			bucketExists = true
-			break
-		}
+	unlock, err := b.Locks.LockBucket(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock bucket"))
+		return
 	}
+	defer unlock()
 
-	if !bucketExists {
-		WriteXMLError(w, http.StatusNotFound, "Bucket does not exist in metadata")
+	if _, err := b.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
 		return
 	}
 
 	bucketPath := filepath.Join(b.BaseDir, bucketName)
 	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
-		WriteXMLError(w, http.StatusNotFound, "Bucket directory does not exist")
+		WriteXMLError(w, r, ErrNoSuchBucket)
 		return
 	}
 
-	objectsCSVPath := filepath.Join(bucketPath, "objects.csv")
-	if _, err := os.Stat(objectsCSVPath); err == nil {
-		records, err := utils.ReadCSVFile(objectsCSVPath)
-		if err != nil {
-			WriteXMLError(w, http.StatusInternalServerError, "Failed to read object metadata")
-			return
-		}
-		if len(records) > 0 {
-			WriteXMLError(w, http.StatusConflict, "Cannot delete non-empty bucket")
-			return
-		}
+	empty, err := isBucketEmpty(b.Store, bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+		return
+	}
+	if !empty {
+		WriteXMLError(w, r, ErrBucketNotEmpty)
+		return
 	}
 
 	if err := os.RemoveAll(bucketPath); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to delete bucket directory")
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to delete bucket directory"))
 		return
 	}
 
-	if err := b.removeBucketMetadata(bucketName); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to update metadata")
+	if err := b.deleteBucketMetadata(bucketName); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update metadata"))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (b *BucketHandler) createBucketMetadata(bucket store.Bucket) error {
+	unlock, err := b.Locks.LockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return b.Store.CreateBucket(bucket)
+}
+
+func (b *BucketHandler) deleteBucketMetadata(bucketName string) error {
+	unlock, err := b.Locks.LockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return b.Store.DeleteBucket(bucketName)
+}
+
+// updateBucketConfig applies change to a bucket's metadata. The exclusive
+// bucket lock waits for in-flight writes, so none of them mixes the old and
+// the new configuration.
+func (b *BucketHandler) updateBucketConfig(w http.ResponseWriter, r *http.Request, bucketName string, change func(*store.Bucket)) bool {
+	unlock, err := b.Locks.LockBucket(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock bucket"))
+		return false
+	}
+	defer unlock()
+
+	unlockMetadata, err := b.Locks.LockMetadata()
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock metadata"))
+		return false
+	}
+	defer unlockMetadata()
+
+	bucket, err := b.Store.GetBucket(bucketName)
+	if errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return false
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return false
+	}
+
+	change(&bucket)
+	bucket.LastModifiedTime = time.Now()
+	if err := b.Store.SaveBucket(bucket); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update metadata"))
+		return false
+	}
+	return true
+}
@@ -1,75 +1,175 @@
 package handlers
 
 import (
-	"encoding/csv"
-	"fmt"
-	"os"
-	"path/filepath"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
 	"time"
-	"triple-s/utils"
+	"triple-s/locks"
+	"triple-s/store"
 )
 
-func (b *BucketHandler) appendBucketMetadata(bucket Bucket) error {
-	metadataFile := filepath.Join(b.BaseDir, "buckets.csv") 
-	file, err := os.OpenFile(metadataFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+func bucketFromRecord(record store.Bucket) Bucket {
+	return Bucket{
+		Name:             record.Name,
+		CreationTime:     record.CreationTime,
+		LastModifiedTime: record.LastModifiedTime,
+		Status:           record.Status,
+	}
+}
+
+func isBucketEmpty(metadata store.MetadataStore, bucketName string) (bool, error) {
+	objects, err := metadata.ListObjects(bucketName)
+	if err != nil {
+		return false, err
+	}
+	versions, err := metadata.ListVersions(bucketName)
+	if err != nil {
+		return false, err
+	}
+	return len(objects) == 0 && len(versions) == 0, nil
+}
+
+// updateBucketStatus refreshes the bucket's last modified time and status
+// after its objects changed. The caller must hold the bucket metadata lock.
+func updateBucketStatus(metadata store.MetadataStore, lockManager *locks.Manager, bucketName string, lastModified time.Time) error {
+	empty, err := isBucketEmpty(metadata, bucketName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	status := "active"
+	if empty {
+		status = "marked for deletion"
+	}
 
-	record := []string{
-		bucket.Name,
-		bucket.CreationTime.Format(time.RFC3339),
-		bucket.LastModifiedTime.Format(time.RFC3339),
-		bucket.Status,
+	unlock, err := lockManager.LockMetadata()
+	if err != nil {
+		return err
 	}
-	return writer.Write(record)
+	defer unlock()
+
+	return metadata.UpdateBucket(bucketName, lastModified, status)
 }
 
-func (b *BucketHandler) removeBucketMetadata(bucketName string) error {
-	metadataFile := filepath.Join(b.BaseDir, "buckets.csv")
+const (
+	maxListKeys  = 1000
+	s3TimeFormat = "2006-01-02T15:04:05.000Z"
+)
 
-	records, err := utils.ReadCSVFile(metadataFile)
-	if err != nil {
-		return fmt.Errorf("failed to read metadata: %v", err)
+type listObjectsParams struct {
+	Prefix            string
+	Delimiter         string
+	StartAfter        string
+	ContinuationToken string
+	MaxKeys           int
+}
+
+// listObjectsPage builds one page of a ListObjectsV2 response. Keys that
+// share a prefix up to the delimiter are rolled up into a single common
+// prefix, which counts as one entry towards MaxKeys.
+func listObjectsPage(bucketName string, objects []store.Object, params listObjectsParams) (ListBucketResult, error) {
+	result := ListBucketResult{
+		Name:              bucketName,
+		Prefix:            params.Prefix,
+		Delimiter:         params.Delimiter,
+		StartAfter:        params.StartAfter,
+		ContinuationToken: params.ContinuationToken,
+		MaxKeys:           params.MaxKeys,
 	}
 
-	tempFile, err := os.CreateTemp(b.BaseDir, "buckets_temp_*.csv")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %v", err)
+	if params.MaxKeys == 0 {
+		return result, nil
 	}
-	tempFilePath := tempFile.Name()
-	defer func() {
-		tempFile.Close()
+
+	marker := params.StartAfter
+	markerIsPrefix := false
+	if params.ContinuationToken != "" {
+		var err error
+		marker, markerIsPrefix, err = decodeContinuationToken(params.ContinuationToken)
 		if err != nil {
-			os.Remove(tempFilePath)
+			return ListBucketResult{}, err
 		}
-	}()
+	}
 
-	writer := csv.NewWriter(tempFile)
-	defer writer.Flush()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
 
-	for _, record := range records {
-		if record[0] != bucketName {
-			if err := writer.Write(record); err != nil {
-				return fmt.Errorf("failed to write record: %v", err)
+	lastItem := ""
+	lastIsPrefix := false
+	for _, object := range objects {
+		key := object.Key
+		if !strings.HasPrefix(key, params.Prefix) || key <= marker {
+			continue
+		}
+		if markerIsPrefix && strings.HasPrefix(key, marker) {
+			continue
+		}
+
+		commonPrefix := ""
+		if params.Delimiter != "" {
+			rest := key[len(params.Prefix):]
+			if i := strings.Index(rest, params.Delimiter); i >= 0 {
+				commonPrefix = params.Prefix + rest[:i+len(params.Delimiter)]
 			}
 		}
+
+		if commonPrefix != "" && lastIsPrefix && commonPrefix == lastItem {
+			continue
+		}
+
+		if result.KeyCount == params.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{Prefix: commonPrefix})
+			lastItem, lastIsPrefix = commonPrefix, true
+		} else {
+			result.Contents = append(result.Contents, ObjectEntry{
+				Key:          key,
+				LastModified: object.LastModified.UTC().Format(s3TimeFormat),
+				ETag:         quoteETag(object.ETag),
+				Size:         object.Size,
+				StorageClass: "STANDARD",
+			})
+			lastItem, lastIsPrefix = key, false
+		}
+		result.KeyCount++
 	}
 
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		return fmt.Errorf("failed to flush writer: %v", err)
+	if result.IsTruncated {
+		result.NextContinuationToken = encodeContinuationToken(lastItem, lastIsPrefix)
 	}
+	return result, nil
+}
 
-	tempFile.Close()
+// Continuation tokens are opaque to clients; they carry the last key or
+// common prefix that was returned so the next page can resume after it.
+func encodeContinuationToken(last string, isPrefix bool) string {
+	kind := "k:"
+	if isPrefix {
+		kind = "p:"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + last))
+}
 
-	if err := os.Rename(tempFilePath, metadataFile); err != nil {
-		return fmt.Errorf("failed to rename temporary file: %v", err)
+func decodeContinuationToken(token string) (string, bool, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", false, errors.New("the continuation token provided is incorrect")
 	}
 
-	return nil
+	value := string(decoded)
+	switch {
+	case strings.HasPrefix(value, "k:"):
+		return value[2:], false, nil
+	case strings.HasPrefix(value, "p:"):
+		return value[2:], true, nil
+	default:
+		return "", false, errors.New("the continuation token provided is incorrect")
+	}
 }
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"triple-s/store"
+)
+
+func objectsWithKeys(keys ...string) []store.Object {
+	objects := make([]store.Object, len(keys))
+	for i, key := range keys {
+		objects[i] = store.Object{Key: key}
+	}
+	return objects
+}
+
+// pageItems lists the keys of a page followed by its common prefixes.
+func pageItems(result ListBucketResult) []string {
+	var items []string
+	for _, entry := range result.Contents {
+		items = append(items, entry.Key)
+	}
+	for _, prefix := range result.CommonPrefixes {
+		items = append(items, prefix.Prefix)
+	}
+	return items
+}
+
+func TestListObjectsPage(t *testing.T) {
+	keys := []string{"a", "b/1", "b/2", "b/3", "c", "d/1", "e"}
+
+	tests := []struct {
+		name      string
+		params    listObjectsParams
+		items     []string
+		truncated bool
+	}{
+		{"everything", listObjectsParams{MaxKeys: 1000}, keys, false},
+		{"max-keys=0", listObjectsParams{MaxKeys: 0}, nil, false},
+		{"first page", listObjectsParams{MaxKeys: 2}, []string{"a", "b/1"}, true},
+		{"exactly one page", listObjectsParams{MaxKeys: len(keys)}, keys, false},
+		{"prefix", listObjectsParams{Prefix: "b/", MaxKeys: 1000}, []string{"b/1", "b/2", "b/3"}, false},
+		{"delimiter", listObjectsParams{Delimiter: "/", MaxKeys: 1000}, []string{"a", "c", "e", "b/", "d/"}, false},
+		{"common prefix counts once", listObjectsParams{Delimiter: "/", MaxKeys: 2}, []string{"a", "b/"}, true},
+		{"start-after", listObjectsParams{StartAfter: "b/2", MaxKeys: 1000}, []string{"b/3", "c", "d/1", "e"}, false},
+		{"start-after inside a common prefix", listObjectsParams{StartAfter: "b/1", Delimiter: "/", MaxKeys: 1000}, []string{"c", "e", "b/", "d/"}, false},
+		{"start-after past the end", listObjectsParams{StartAfter: "z", MaxKeys: 1000}, nil, false},
+		{"continuation-token wins over start-after", listObjectsParams{
+			StartAfter:        "d/1",
+			ContinuationToken: encodeContinuationToken("a", false),
+			MaxKeys:           1,
+		}, []string{"b/1"}, true},
+		{"continuation-token after a common prefix", listObjectsParams{
+			Delimiter:         "/",
+			ContinuationToken: encodeContinuationToken("b/", true),
+			MaxKeys:           1000,
+		}, []string{"c", "e", "d/"}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := listObjectsPage(testBucket, objectsWithKeys(keys...), tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if items := pageItems(result); !reflect.DeepEqual(items, tc.items) {
+				t.Fatalf("got %q, want %q", items, tc.items)
+			}
+			if result.KeyCount != len(tc.items) {
+				t.Fatalf("got KeyCount %d, want %d", result.KeyCount, len(tc.items))
+			}
+			if result.IsTruncated != tc.truncated || (result.NextContinuationToken != "") != tc.truncated {
+				t.Fatalf("got truncated %v with next token %q, want truncated %v",
+					result.IsTruncated, result.NextContinuationToken, tc.truncated)
+			}
+		})
+	}
+}
+
+// Following the continuation tokens one item at a time visits every key and
+// common prefix exactly once, whatever the delimiter.
+func TestListObjectsPageContinuation(t *testing.T) {
+	keys := []string{"a", "b/1", "b/2", "b/3", "c", "d/1", "e"}
+
+	for _, delimiter := range []string{"", "/"} {
+		all, err := listObjectsPage(testBucket, objectsWithKeys(keys...), listObjectsParams{Delimiter: delimiter, MaxKeys: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var paged []string
+		params := listObjectsParams{Delimiter: delimiter, MaxKeys: 1}
+		for page := 0; ; page++ {
+			if page > len(keys) {
+				t.Fatalf("delimiter %q: listing does not end", delimiter)
+			}
+			result, err := listObjectsPage(testBucket, objectsWithKeys(keys...), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paged = append(paged, pageItems(result)...)
+			if !result.IsTruncated {
+				break
+			}
+			params.ContinuationToken = result.NextContinuationToken
+		}
+
+		want := pageItems(all)
+		if len(paged) != len(want) {
+			t.Fatalf("delimiter %q: paging returned %q, want the items of %q", delimiter, paged, want)
+		}
+		seen := make(map[string]bool)
+		for _, item := range paged {
+			if seen[item] {
+				t.Fatalf("delimiter %q: %q listed twice in %q", delimiter, item, paged)
+			}
+			seen[item] = true
+		}
+		for _, item := range want {
+			if !seen[item] {
+				t.Fatalf("delimiter %q: %q missing from %q", delimiter, item, paged)
+			}
+		}
+	}
+}
+
+func TestListObjectsV2Parameters(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	for _, key := range []string{"a", "b", "c"} {
+		expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/"+key, key), http.StatusOK, "PUT "+key)
+	}
+
+	tests := []struct {
+		query  url.Values
+		status int
+		keys   []string
+	}{
+		{url.Values{"list-type": {"2"}, "max-keys": {"0"}}, http.StatusOK, nil},
+		{url.Values{"list-type": {"2"}, "max-keys": {"1"}, "start-after": {"a"}}, http.StatusOK, []string{"b"}},
+		{url.Values{"list-type": {"2"}, "max-keys": {"-1"}}, http.StatusBadRequest, nil},
+		{url.Values{"list-type": {"2"}, "max-keys": {"many"}}, http.StatusBadRequest, nil},
+		{url.Values{"list-type": {"2"}, "continuation-token": {"not a token"}}, http.StatusBadRequest, nil},
+		{url.Values{"list-type": {"1"}}, http.StatusNotImplemented, nil},
+	}
+
+	for _, tc := range tests {
+		w := do(t, h, http.MethodGet, "/"+testBucket+"?"+tc.query.Encode(), "")
+		expectStatus(t, w, tc.status, "ListObjectsV2 "+tc.query.Encode())
+		if tc.status != http.StatusOK {
+			if !strings.Contains(w.Body.String(), "<Code>") {
+				t.Fatalf("ListObjectsV2 %s: got %s, want an error document", tc.query.Encode(), w.Body.String())
+			}
+			continue
+		}
+
+		var result ListBucketResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if items := pageItems(result); !reflect.DeepEqual(items, tc.keys) {
+			t.Fatalf("ListObjectsV2 %s: got %q, want %q", tc.query.Encode(), items, tc.keys)
+		}
+	}
+}
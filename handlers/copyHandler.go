@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"triple-s/store"
+	"triple-s/utils"
+)
+
+// CopyObject handles a PUT carrying x-amz-copy-source by copying an
+// existing object on the server, within a bucket or across buckets.
+func (o *ObjectHandler) CopyObject(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	if err := utils.ValidateObjectKey(objectKey); err != nil {
+		WriteXMLError(w, r, objectKeyError(err))
+		return
+	}
+
+	sourceBucket, sourceKey, sourceVersionID, err := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
+		return
+	}
+
+	sourceAction := "s3:GetObject"
+	if sourceVersionID != "" {
+		sourceAction = "s3:GetObjectVersion"
+	}
+	if !authorize(o.Store, w, r, sourceBucket, sourceKey, sourceVersionID, sourceAction) {
+		return
+	}
+
+	directive := strings.ToUpper(r.Header.Get("X-Amz-Metadata-Directive"))
+	if directive == "" {
+		directive = "COPY"
+	}
+	if directive != "COPY" && directive != "REPLACE" {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage("Unknown metadata directive"))
+		return
+	}
+
+	if sourceBucket == bucketName && sourceKey == objectKey && sourceVersionID == "" && directive == "COPY" {
+		WriteXMLError(w, r, ErrInvalidRequest.WithMessage("This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata"))
+		return
+	}
+
+	userMetadata, err := userMetadataFromRequest(r)
+	if err != nil {
+		WriteXMLError(w, r, ErrMetadataTooLarge.WithMessage(err.Error()))
+		return
+	}
+	acl, err := aclFromRequest(r)
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
+		return
+	}
+
+	unlock, ok := o.lockBuckets(w, r, bucketName, sourceBucket)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	source, sourcePath, err := lookupVersion(o.Store, o.BaseDir, sourceBucket, sourceKey, sourceVersionID)
+	if errors.Is(err, errNoSuchKey) {
+		WriteXMLError(w, r, ErrNoSuchKey)
+		return
+	} else if errors.Is(err, errNoSuchVersion) {
+		WriteXMLError(w, r, ErrNoSuchVersion)
+		return
+	} else if errors.Is(err, errIsDeleteMarker) {
+		WriteXMLError(w, r, ErrInvalidRequest.WithMessage("The source of a copy request may not specifically refer to a delete marker by version id"))
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+		return
+	}
+
+	if checkCopySourcePreconditions(r, source) != http.StatusOK {
+		WriteXMLError(w, r, ErrPreconditionFailed)
+		return
+	}
+
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		WriteXMLError(w, r, ErrNoSuchKey)
+		return
+	}
+	defer sourceFile.Close()
+
+	hash := md5.New()
+	tempPath, size, err := utils.WriteTempFile(filepath.Join(o.BaseDir, bucketName), utils.TempUploadPrefix+"*", io.TeeReader(sourceFile, hash))
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to copy object"))
+		return
+	}
+	defer os.Remove(tempPath)
+
+	object := source
+	object.Key = objectKey
+	object.Size = size
+	object.LastModified = time.Now()
+	object.ETag = hex.EncodeToString(hash.Sum(nil))
+	object.ACL = acl
+	if directive == "REPLACE" {
+		applyContentHeaders(r, &object)
+		object.UserMetadata = userMetadata
+	}
+
+	if !o.commitObject(w, r, bucketName, tempPath, object) {
+		return
+	}
+
+	if source.VersionID != "" {
+		w.Header().Set("X-Amz-Copy-Source-Version-Id", source.VersionID)
+	}
+	response := CopyObjectResult{
+		ETag:         quoteETag(object.ETag),
+		LastModified: object.LastModified.UTC().Format(s3TimeFormat),
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+// lockBuckets takes the shared lock of every distinct bucket in name order,
+// so that two copies in opposite directions cannot deadlock.
+func (o *ObjectHandler) lockBuckets(w http.ResponseWriter, r *http.Request, bucketNames ...string) (func(), bool) {
+	sort.Strings(bucketNames)
+
+	var unlocks []func()
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for i, bucketName := range bucketNames {
+		if i > 0 && bucketName == bucketNames[i-1] {
+			continue
+		}
+		unlock, ok := o.lockBucket(w, r, bucketName)
+		if !ok {
+			unlockAll()
+			return nil, false
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, true
+}
+
+// parseCopySource splits an x-amz-copy-source value of the form
+// [/]bucket/key[?versionId=id] into its parts. The value may be URL-encoded.
+func parseCopySource(value string) (string, string, string, error) {
+	source, query, _ := strings.Cut(value, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source: %v", err)
+	}
+	versionID := values.Get("versionId")
+	if query != "" && versionID == "" {
+		return "", "", "", errors.New("copy source may only carry a versionId")
+	}
+
+	decoded, err := url.PathUnescape(source)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source: %v", err)
+	}
+
+	bucketName, key, ok := strings.Cut(strings.TrimPrefix(decoded, "/"), "/")
+	if !ok || bucketName == "" {
+		return "", "", "", errors.New("copy source must be of the form bucket/key")
+	}
+	if err := utils.ValidateObjectKey(key); err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source key: %v", err)
+	}
+	return bucketName, key, versionID, nil
+}
+
+// checkCopySourcePreconditions evaluates the x-amz-copy-source-if-* headers
+// against the source object. Any failure is reported as 412.
+func checkCopySourcePreconditions(r *http.Request, source store.Object) int {
+	conditional := &http.Request{Header: http.Header{}}
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if value := r.Header.Get("X-Amz-Copy-Source-" + name); value != "" {
+			conditional.Header.Set(name, value)
+		}
+	}
+
+	if checkPreconditions(conditional, source, true, true) != http.StatusOK {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCopyObjectMetadataDirective(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/source", "data",
+		"Content-Type", "text/plain",
+		"Cache-Control", "max-age=60",
+		"X-Amz-Meta-Color", "red")
+	expectStatus(t, w, http.StatusOK, "PUT source")
+	etag := w.Header().Get("ETag")
+
+	tests := []struct {
+		name   string
+		key    string
+		header []string
+		// want lists the response headers of a HEAD on the copy; an empty
+		// value means the header must be absent.
+		want map[string]string
+	}{
+		{
+			name: "COPY by default",
+			key:  "copy",
+			header: []string{
+				"Content-Type", "application/json",
+				"X-Amz-Meta-Color", "blue",
+			},
+			want: map[string]string{
+				"Content-Type":     "text/plain",
+				"Cache-Control":    "max-age=60",
+				"X-Amz-Meta-Color": "red",
+			},
+		},
+		{
+			name: "REPLACE",
+			key:  "replaced",
+			header: []string{
+				"X-Amz-Metadata-Directive", "REPLACE",
+				"Content-Type", "application/json",
+				"X-Amz-Meta-Shape", "round",
+			},
+			want: map[string]string{
+				"Content-Type":     "application/json",
+				"Cache-Control":    "",
+				"X-Amz-Meta-Color": "",
+				"X-Amz-Meta-Shape": "round",
+			},
+		},
+		{
+			name: "directive is case-insensitive",
+			key:  "lower",
+			header: []string{
+				"X-Amz-Metadata-Directive", "replace",
+				"X-Amz-Meta-Shape", "square",
+			},
+			want: map[string]string{
+				"X-Amz-Meta-Color": "",
+				"X-Amz-Meta-Shape": "square",
+			},
+		},
+		{
+			name: "REPLACE onto the source itself",
+			key:  "source",
+			header: []string{
+				"X-Amz-Metadata-Directive", "REPLACE",
+				"Content-Type", "text/csv",
+			},
+			want: map[string]string{
+				"Content-Type":     "text/csv",
+				"X-Amz-Meta-Color": "",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		header := append([]string{"X-Amz-Copy-Source", testBucket + "/source"}, tc.header...)
+		w := do(t, h, http.MethodPut, "/"+testBucket+"/"+tc.key, "", header...)
+		expectStatus(t, w, http.StatusOK, tc.name)
+		var result CopyObjectResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if result.ETag != etag {
+			t.Fatalf("%s: got ETag %s, want the source's %s", tc.name, result.ETag, etag)
+		}
+
+		expectObject(t, h, tc.key, "data")
+		w = do(t, h, http.MethodHead, "/"+testBucket+"/"+tc.key, "")
+		expectStatus(t, w, http.StatusOK, tc.name+": HEAD")
+		for name, value := range tc.want {
+			if got := w.Header().Get(name); got != value {
+				t.Fatalf("%s: got %s %q, want %q", tc.name, name, got, value)
+			}
+		}
+	}
+}
+
+func TestCopyObjectRejects(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/source", "data"), http.StatusOK, "PUT source")
+
+	tests := []struct {
+		name   string
+		key    string
+		header []string
+		status int
+		code   string
+	}{
+		{"copy onto itself", "source", []string{"X-Amz-Copy-Source", testBucket + "/source"}, http.StatusBadRequest, "InvalidRequest"},
+		{"explicit COPY onto itself", "source", []string{"X-Amz-Copy-Source", testBucket + "/source", "X-Amz-Metadata-Directive", "COPY"}, http.StatusBadRequest, "InvalidRequest"},
+		{"unknown directive", "copy", []string{"X-Amz-Copy-Source", testBucket + "/source", "X-Amz-Metadata-Directive", "MERGE"}, http.StatusBadRequest, "InvalidArgument"},
+		{"missing source", "copy", []string{"X-Amz-Copy-Source", testBucket + "/missing"}, http.StatusNotFound, "NoSuchKey"},
+		{"malformed source", "copy", []string{"X-Amz-Copy-Source", testBucket}, http.StatusBadRequest, "InvalidArgument"},
+		{"source precondition", "copy", []string{"X-Amz-Copy-Source", testBucket + "/source", "X-Amz-Copy-Source-If-Match", `"other"`}, http.StatusPreconditionFailed, "PreconditionFailed"},
+	}
+
+	for _, tc := range tests {
+		w := do(t, h, http.MethodPut, "/"+testBucket+"/"+tc.key, "", tc.header...)
+		expectStatus(t, w, tc.status, tc.name)
+		if !strings.Contains(w.Body.String(), "<Code>"+tc.code+"</Code>") {
+			t.Fatalf("%s: got %s, want %s", tc.name, w.Body.String(), tc.code)
+		}
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/copy", ""), http.StatusNotFound, "GET copy")
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"triple-s/policy"
+	"triple-s/utils"
+)
+
+const (
+	maxDeleteObjects = 1000
+	maxDeleteBody    = 4 << 20
+)
+
+// DeleteObjects handles POST /{bucket}?delete. Keys in a bucket that was
+// never versioned are removed with a single metadata update; anything that
+// touches versions is removed key by key under the same locks.
+func (b *BucketHandler) DeleteObjects(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDeleteBody+1))
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage("Failed to read request body"))
+		return
+	}
+	if len(body) > maxDeleteBody {
+		WriteXMLError(w, r, ErrMalformedXML.WithMessage("Request body is too large"))
+		return
+	}
+
+	if digest := r.Header.Get("Content-MD5"); digest != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(digest); err != nil || len(decoded) != md5.Size {
+			WriteXMLError(w, r, ErrInvalidDigest)
+			return
+		}
+		sum := md5.Sum(body)
+		if digest != base64.StdEncoding.EncodeToString(sum[:]) {
+			WriteXMLError(w, r, ErrBadDigest)
+			return
+		}
+	}
+
+	var request DeleteRequest
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&request); err != nil {
+		WriteXMLError(w, r, ErrMalformedXML)
+		return
+	}
+	if len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		WriteXMLError(w, r, ErrMalformedXML.WithMessage("A delete request must name between 1 and 1000 objects"))
+		return
+	}
+
+	checker, err := newAccessChecker(b.Store, r, bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket policy"))
+		return
+	}
+
+	objects := &ObjectHandler{BaseDir: b.BaseDir, Store: b.Store, Locks: b.Locks}
+	unlock, ok := objects.lockBucket(w, r, bucketName)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Object locks are taken in key order so that two overlapping batches
+	// cannot deadlock.
+	keys := make([]string, 0, len(request.Objects))
+	for _, target := range request.Objects {
+		keys = append(keys, target.Key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		unlockObject, err := b.Locks.LockObject(bucketName, key)
+		if err != nil {
+			WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock object"))
+			return
+		}
+		defer unlockObject()
+	}
+
+	unlockMetadata, err := b.Locks.LockBucketMetadata(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock bucket metadata"))
+		return
+	}
+	defer unlockMetadata()
+
+	bucket, err := b.Store.GetBucket(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+	current, err := b.Store.ListObjects(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+		return
+	}
+	existing := make(map[string]bool, len(current))
+	for _, object := range current {
+		existing[object.Key] = true
+	}
+
+	var response DeleteResult
+	var batch []string
+	trashed := make(map[string]string)
+	changed := false
+	bucketPath := filepath.Join(b.BaseDir, bucketName)
+	for _, target := range request.Objects {
+		if err := utils.ValidateObjectKey(target.Key); err != nil {
+			response.Errors = append(response.Errors, deleteFailure(target, objectKeyError(err)))
+			continue
+		}
+
+		action := "s3:DeleteObject"
+		if target.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		if !checker.allows(action, policy.Resource(bucketName, target.Key), checker.bucketACL) {
+			response.Errors = append(response.Errors, deleteFailure(target, ErrAccessDenied))
+			continue
+		}
+
+		if bucket.Versioning == "" && target.VersionID == "" {
+			// Deleting a key that does not exist still counts as deleted.
+			if existing[target.Key] {
+				trashPath, err := trashFile(bucketPath, objectPath(bucketPath, target.Key))
+				if err != nil {
+					response.Errors = append(response.Errors, deleteFailure(target, ErrInternalError.WithMessage("Failed to delete object")))
+					continue
+				}
+				trashed[target.Key] = trashPath
+				batch = append(batch, target.Key)
+				delete(existing, target.Key)
+			}
+			response.Deleted = append(response.Deleted, DeletedEntry{Key: target.Key})
+			continue
+		}
+
+		result, err := objects.removeObjectLocked(bucket, target.Key, target.VersionID)
+		switch {
+		case errors.Is(err, errNoSuchKey):
+			response.Deleted = append(response.Deleted, DeletedEntry{Key: target.Key})
+		case errors.Is(err, errNoSuchVersion):
+			response.Errors = append(response.Errors, deleteFailure(target, ErrNoSuchVersion))
+		case err != nil:
+			response.Errors = append(response.Errors, deleteFailure(target, ErrInternalError.WithMessage("Failed to delete object")))
+		default:
+			changed = true
+			deleted := DeletedEntry{Key: target.Key, VersionID: target.VersionID}
+			if result.DeleteMarker {
+				deleted.DeleteMarker = true
+				deleted.DeleteMarkerVersionID = result.VersionID
+			}
+			response.Deleted = append(response.Deleted, deleted)
+		}
+	}
+
+	if err := b.Store.DeleteObjects(bucketName, batch); err != nil {
+		for key, trashPath := range trashed {
+			restoreFile(trashPath, objectPath(bucketPath, key))
+		}
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update object metadata"))
+		return
+	}
+	for _, trashPath := range trashed {
+		emptyTrash(trashPath)
+	}
+	if changed || len(batch) > 0 {
+		if err := updateBucketStatus(b.Store, b.Locks, bucketName, time.Now()); err != nil {
+			WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update bucket metadata"))
+			return
+		}
+	}
+
+	if request.Quiet {
+		response.Deleted = nil
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func deleteFailure(target DeleteTarget, apiErr APIError) DeleteFailure {
+	return DeleteFailure{Key: target.Key, VersionID: target.VersionID, Code: apiErr.Code, Message: apiErr.Message}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func deleteObjects(t *testing.T, h http.Handler, quiet bool, targets ...DeleteTarget) DeleteResult {
+	t.Helper()
+
+	body, err := xml.Marshal(DeleteRequest{Quiet: quiet, Objects: targets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := do(t, h, http.MethodPost, "/"+testBucket+"?delete", string(body))
+	expectStatus(t, w, http.StatusOK, "DeleteObjects")
+	var result DeleteResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestDeleteObjectsReportsEachKey(t *testing.T) {
+	tooLong := strings.Repeat("k", 1025)
+	targets := []DeleteTarget{
+		{Key: "a"},
+		{Key: "missing"},
+		{Key: "locked/b"},
+		{Key: "../escape"},
+		{Key: tooLong},
+		{Key: "c", VersionID: "no-such-version"},
+	}
+	wantErrors := map[string]string{
+		"locked/b":  "AccessDenied",
+		"../escape": "InvalidArgument",
+		tooLong:     "KeyTooLongError",
+		"c":         "NoSuchVersion",
+	}
+
+	for _, quiet := range []bool{false, true} {
+		h := newTestServer(t)
+		createBucket(t, h)
+		for _, key := range []string{"a", "locked/b", "c"} {
+			expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/"+key, key), http.StatusOK, "PUT "+key)
+		}
+		policy := `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:DeleteObject", "Resource": "arn:aws:s3:::` + testBucket + `/locked/*"}]}`
+		expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"?policy", policy), http.StatusNoContent, "PutBucketPolicy")
+
+		result := deleteObjects(t, h, quiet, targets...)
+
+		var deleted []string
+		for _, entry := range result.Deleted {
+			deleted = append(deleted, entry.Key)
+		}
+		wantDeleted := []string{"a", "missing"}
+		if quiet {
+			// Quiet mode reports only the keys that failed.
+			wantDeleted = nil
+		}
+		if !reflect.DeepEqual(deleted, wantDeleted) {
+			t.Fatalf("quiet %v: got deleted %q, want %q", quiet, deleted, wantDeleted)
+		}
+
+		failures := make(map[string]string)
+		for _, failure := range result.Errors {
+			failures[failure.Key] = failure.Code
+			if failure.Message == "" {
+				t.Fatalf("quiet %v: error for %q has no message", quiet, failure.Key)
+			}
+		}
+		if !reflect.DeepEqual(failures, wantErrors) {
+			t.Fatalf("quiet %v: got errors %v, want %v", quiet, failures, wantErrors)
+		}
+
+		expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/a", ""), http.StatusNotFound, "GET a")
+		expectObject(t, h, "locked/b", "locked/b")
+		expectObject(t, h, "c", "c")
+	}
+}
+
+func TestDeleteObjectsRejectsRequest(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+
+	many := make([]string, maxDeleteObjects+1)
+	for i := range many {
+		many[i] = "<Object><Key>k</Key></Object>"
+	}
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		code   string
+	}{
+		{"no objects", "<Delete></Delete>", nil, "MalformedXML"},
+		{"too many objects", "<Delete>" + strings.Join(many, "") + "</Delete>", nil, "MalformedXML"},
+		{"not XML", "delete a", nil, "MalformedXML"},
+		{"bad Content-MD5", "<Delete><Object><Key>a</Key></Object></Delete>", []string{"Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg=="}, "BadDigest"},
+		{"malformed Content-MD5", "<Delete><Object><Key>a</Key></Object></Delete>", []string{"Content-MD5", "md5"}, "InvalidDigest"},
+	}
+
+	for _, tc := range tests {
+		w := do(t, h, http.MethodPost, "/"+testBucket+"?delete", tc.body, tc.header...)
+		expectStatus(t, w, http.StatusBadRequest, tc.name)
+		if !strings.Contains(w.Body.String(), "<Code>"+tc.code+"</Code>") {
+			t.Fatalf("%s: got %s, want %s", tc.name, w.Body.String(), tc.code)
+		}
+	}
+}
+
+func TestDeleteObjectsVersioned(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	enableVersioning(t, h)
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/key", "v1")
+	expectStatus(t, w, http.StatusOK, "PUT")
+	versionID := w.Header().Get("X-Amz-Version-Id")
+
+	result := deleteObjects(t, h, false, DeleteTarget{Key: "key"})
+	if len(result.Deleted) != 1 || !result.Deleted[0].DeleteMarker || result.Deleted[0].DeleteMarkerVersionID == "" {
+		t.Fatalf("got %+v, want a delete marker", result.Deleted)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/key", ""), http.StatusNotFound, "GET after delete")
+
+	result = deleteObjects(t, h, false,
+		DeleteTarget{Key: "key", VersionID: result.Deleted[0].DeleteMarkerVersionID},
+		DeleteTarget{Key: "key", VersionID: versionID})
+	if len(result.Errors) != 0 || len(result.Deleted) != 2 {
+		t.Fatalf("got deleted %+v and errors %+v, want both versions deleted", result.Deleted, result.Errors)
+	}
+	if listed := listVersions(t, h, ""); len(listed.Versions) != 0 {
+		t.Fatalf("got %d versions left, want none", len(listed.Versions))
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import "net/http"
+
+// APIError is an S3 error: the code SDKs match on, the status it is sent
+// with and a default message.
+type APIError struct {
+	Code       string
+	StatusCode int
+	Message    string
+}
+
+// WithMessage returns the error with a more specific message.
+func (e APIError) WithMessage(message string) APIError {
+	e.Message = message
+	return e
+}
+
+var (
+	ErrAccessDenied = APIError{"AccessDenied", http.StatusForbidden, "Access Denied"}
+
+	ErrAuthorizationHeaderMalformed      = APIError{"AuthorizationHeaderMalformed", http.StatusBadRequest, "The authorization header is malformed"}
+	ErrAuthorizationQueryParametersError = APIError{"AuthorizationQueryParametersError", http.StatusBadRequest, "The authorization query parameters are malformed"}
+
+	ErrBadDigest = APIError{"BadDigest", http.StatusBadRequest, "The Content-MD5 you specified did not match what we received"}
+
+	ErrBucketAlreadyExists = APIError{"BucketAlreadyExists", http.StatusConflict, "The requested bucket name is not available"}
+
+	ErrBucketNotEmpty = APIError{"BucketNotEmpty", http.StatusConflict, "The bucket you tried to delete is not empty"}
+
+	ErrEntityTooLarge = APIError{"EntityTooLarge", http.StatusBadRequest, "Your proposed upload exceeds the maximum allowed object size"}
+	ErrEntityTooSmall = APIError{"EntityTooSmall", http.StatusBadRequest, "Your proposed upload is smaller than the minimum allowed object size"}
+
+	ErrIllegalVersioningConfiguration = APIError{"IllegalVersioningConfigurationException", http.StatusBadRequest, "The versioning configuration specified in the request is invalid"}
+
+	ErrIncompleteBody = APIError{"IncompleteBody", http.StatusBadRequest, "You did not provide the number of bytes specified by the Content-Length HTTP header"}
+
+	ErrInternalError = APIError{"InternalError", http.StatusInternalServerError, "We encountered an internal error. Please try again."}
+
+	ErrInvalidAccessKeyID = APIError{"InvalidAccessKeyId", http.StatusForbidden, "The AWS access key ID you provided does not exist in our records"}
+
+	ErrInvalidArgument = APIError{"InvalidArgument", http.StatusBadRequest, "Invalid argument"}
+
+	ErrInvalidBucketName = APIError{"InvalidBucketName", http.StatusBadRequest, "The specified bucket is not valid"}
+
+	ErrInvalidDigest = APIError{"InvalidDigest", http.StatusBadRequest, "The Content-MD5 you specified is not valid"}
+
+	ErrInvalidPart      = APIError{"InvalidPart", http.StatusBadRequest, "One or more of the specified parts could not be found"}
+	ErrInvalidPartOrder = APIError{"InvalidPartOrder", http.StatusBadRequest, "The list of parts was not in ascending order"}
+
+	ErrInvalidRange = APIError{"InvalidRange", http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable"}
+
+	ErrInvalidRequest = APIError{"InvalidRequest", http.StatusBadRequest, "Invalid request"}
+
+	ErrKeyTooLong = APIError{"KeyTooLongError", http.StatusBadRequest, "Your key is too long"}
+
+	ErrMalformedACL    = APIError{"MalformedACLError", http.StatusBadRequest, "The XML you provided was not well-formed or did not validate against our published schema"}
+	ErrMalformedPolicy = APIError{"MalformedPolicy", http.StatusBadRequest, "The policy is not valid"}
+	ErrMalformedXML    = APIError{"MalformedXML", http.StatusBadRequest, "The XML you provided was not well-formed or did not validate against our published schema"}
+
+	ErrMetadataTooLarge = APIError{"MetadataTooLarge", http.StatusBadRequest, "Your metadata headers exceed the maximum allowed metadata size"}
+
+	ErrMethodNotAllowed = APIError{"MethodNotAllowed", http.StatusMethodNotAllowed, "The specified method is not allowed against this resource"}
+
+	ErrNoSuchBucket       = APIError{"NoSuchBucket", http.StatusNotFound, "The specified bucket does not exist"}
+	ErrNoSuchBucketPolicy = APIError{"NoSuchBucketPolicy", http.StatusNotFound, "The bucket policy does not exist"}
+	ErrNoSuchKey          = APIError{"NoSuchKey", http.StatusNotFound, "The specified key does not exist"}
+	ErrNoSuchUpload       = APIError{"NoSuchUpload", http.StatusNotFound, "The specified multipart upload does not exist"}
+	ErrNoSuchVersion      = APIError{"NoSuchVersion", http.StatusNotFound, "The specified version does not exist"}
+
+	ErrNotImplemented = APIError{"NotImplemented", http.StatusNotImplemented, "A header you provided implies functionality that is not implemented"}
+
+	ErrPreconditionFailed = APIError{"PreconditionFailed", http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold"}
+
+	ErrRequestTimeTooSkewed = APIError{"RequestTimeTooSkewed", http.StatusForbidden, "The difference between the request time and the server's time is too large"}
+
+	ErrSignatureDoesNotMatch = APIError{"SignatureDoesNotMatch", http.StatusForbidden, "The request signature we calculated does not match the signature you provided"}
+
+	ErrXAmzContentSHA256Mismatch = APIError{"XAmzContentSHA256Mismatch", http.StatusBadRequest, "The provided 'x-amz-content-sha256' header does not match what was computed"}
+)
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// LogHandler gives every request an x-amz-request-id and x-amz-id-2 and
+// writes one access log record for it once Next has answered.
+type LogHandler struct {
+	Logger *slog.Logger
+	Next   http.Handler
+}
+
+func (l *LogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := newRequestID()
+	w.Header().Set("X-Amz-Request-Id", requestID)
+	w.Header().Set("X-Amz-Id-2", newHostID())
+
+	body := &countingReader{r: r.Body}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = body
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+	l.Next.ServeHTTP(recorder, r)
+
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	l.Logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+		slog.String("request_id", requestID),
+		slog.String("method", r.Method),
+		slog.String("bucket", bucketName),
+		slog.String("key", objectKey),
+		slog.Int("status", recorder.status()),
+		slog.Int64("bytes_in", body.n),
+		slog.Int64("bytes_out", recorder.written),
+		slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+}
+
+// newHostID returns a value in the shape of the x-amz-id-2 S3 sends. There
+// is only one host, so it is just another random token.
+func newHostID() string {
+	id := make([]byte, 32)
+	rand.Read(id)
+	return base64.StdEncoding.EncodeToString(id)
+}
+
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code    int
+	written int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) status() int {
+	if s.code == 0 {
+		return http.StatusOK
+	}
+	return s.code
+}
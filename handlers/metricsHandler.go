@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"triple-s/metrics"
+	"triple-s/store"
+)
+
+// Metrics holds the series the server updates while it runs. Bucket and
+// object figures are read from the metadata on every scrape instead.
+type Metrics struct {
+	requests    *metrics.Counter
+	duration    *metrics.Histogram
+	bytesIn     *metrics.Counter
+	bytesOut    *metrics.Counter
+	connections *metrics.Gauge
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:    metrics.NewCounter("triples_requests_total", "Requests served, by S3 operation and status code.", "operation", "status"),
+		duration:    metrics.NewHistogram("triples_request_duration_seconds", "Time to serve a request, by S3 operation and status code.", metrics.DurationBuckets, "operation", "status"),
+		bytesIn:     metrics.NewCounter("triples_received_bytes_total", "Request body bytes received, by S3 operation.", "operation"),
+		bytesOut:    metrics.NewCounter("triples_sent_bytes_total", "Response body bytes sent, by S3 operation.", "operation"),
+		connections: metrics.NewGauge("triples_active_connections", "Client connections currently open."),
+	}
+}
+
+// ConnState keeps count of the open connections; it is meant for
+// http.Server.ConnState.
+func (m *Metrics) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.connections.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		m.connections.Add(-1)
+	}
+}
+
+// MetricsHandler records every request before passing it to Next.
+type MetricsHandler struct {
+	Metrics *Metrics
+	Next    http.Handler
+}
+
+func (m *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	op := operation(r)
+	body := &countingReader{r: r.Body}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = body
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+	m.Next.ServeHTTP(recorder, r)
+
+	status := strconv.Itoa(recorder.status())
+	m.Metrics.requests.Add(1, op, status)
+	m.Metrics.duration.Observe(time.Since(start).Seconds(), op, status)
+	m.Metrics.bytesIn.Add(float64(body.n), op)
+	m.Metrics.bytesOut.Add(float64(recorder.written), op)
+}
+
+// MetricsEndpoint answers scrapes in the Prometheus text format. It is not
+// authenticated and reveals every bucket name, so it is only served on its
+// own -metrics-listen address, away from the S3 API.
+type MetricsEndpoint struct {
+	Metrics *Metrics
+	Store   store.MetadataStore
+}
+
+func (m *MetricsEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buckets, err := m.Store.ListBuckets()
+	if err != nil {
+		http.Error(w, "Failed to read bucket metadata", http.StatusInternalServerError)
+		return
+	}
+
+	bucketCount := metrics.NewGauge("triples_buckets", "Buckets that exist.")
+	objectCount := metrics.NewGauge("triples_bucket_objects", "Objects in the bucket, not counting noncurrent versions and delete markers.", "bucket")
+	storedBytes := metrics.NewGauge("triples_bucket_bytes", "Bytes stored in the bucket, noncurrent versions included.", "bucket")
+
+	bucketCount.Set(float64(len(buckets)))
+	for _, bucket := range buckets {
+		objects, err := m.Store.ListObjects(bucket.Name)
+		if err != nil {
+			http.Error(w, "Failed to read object metadata", http.StatusInternalServerError)
+			return
+		}
+		versions, err := m.Store.ListVersions(bucket.Name)
+		if err != nil {
+			http.Error(w, "Failed to read version metadata", http.StatusInternalServerError)
+			return
+		}
+
+		var count, size int64
+		for _, object := range objects {
+			if !object.DeleteMarker {
+				count++
+				size += object.Size
+			}
+		}
+		for _, version := range versions {
+			if !version.DeleteMarker {
+				size += version.Size
+			}
+		}
+		objectCount.Set(float64(count), bucket.Name)
+		storedBytes.Set(float64(size), bucket.Name)
+	}
+
+	var body bytes.Buffer
+	for _, metric := range []metrics.Metric{
+		m.Metrics.requests, m.Metrics.duration, m.Metrics.bytesIn, m.Metrics.bytesOut,
+		m.Metrics.connections, bucketCount, objectCount, storedBytes,
+	} {
+		metric.Write(&body)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+// operation names the S3 operation of a request for the metric labels.
+func operation(r *http.Request) string {
+	if rt, ok := classify(r); ok {
+		return rt.operation
+	}
+	return "Unknown"
+}
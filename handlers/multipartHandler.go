@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/store"
+	"triple-s/utils"
+)
+
+func (o *ObjectHandler) CreateMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	if err := utils.ValidateObjectKey(objectKey); err != nil {
+		WriteXMLError(w, r, objectKeyError(err))
+		return
+	}
+
+	userMetadata, err := userMetadataFromRequest(r)
+	if err != nil {
+		WriteXMLError(w, r, ErrMetadataTooLarge.WithMessage(err.Error()))
+		return
+	}
+	acl, err := aclFromRequest(r)
+	if err != nil {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
+		return
+	}
+
+	unlock, ok := o.lockBucket(w, r, bucketName)
+	if !ok {
+		return
+	}
+	defer unlock()
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to generate upload ID"))
+		return
+	}
+
+	upload := multipartUpload{
+		UploadID: uploadID,
+		Object: store.Object{
+			Key:          objectKey,
+			LastModified: time.Now(),
+			UserMetadata: userMetadata,
+			ACL:          acl,
+		},
+	}
+	applyContentHeaders(r, &upload.Object)
+	if err := createMultipartUpload(bucketPath, upload); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to create multipart upload"))
+		return
+	}
+
+	response := InitiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      objectKey,
+		UploadID: uploadID,
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (o *ObjectHandler) UploadPart(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(fmt.Sprintf("Part number must be an integer between 1 and %d", maxPartNumber)))
+		return
+	}
+	if r.ContentLength > maxUploadSize {
+		WriteXMLError(w, r, ErrEntityTooLarge)
+		return
+	}
+	// Chunked bodies carry no length up front, so the limit is enforced
+	// while the body is read as well.
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	unlock, ok := o.lockBucket(w, r, bucketName)
+	if !ok {
+		return
+	}
+	defer unlock()
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+
+	if _, ok := o.checkUpload(w, r, bucketPath, uploadID, objectKey); !ok {
+		return
+	}
+
+	hash := md5.New()
+	tempPath, size, err := utils.WriteTempFile(uploadDir(bucketPath, uploadID), utils.TempUploadPrefix+"*", io.TeeReader(r.Body, hash))
+	if err != nil {
+		writeBodyError(w, r, err, "Failed to save part")
+		return
+	}
+	defer os.Remove(tempPath)
+
+	unlockUpload, err := o.Locks.LockUpload(bucketName, uploadID)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock upload"))
+		return
+	}
+	defer unlockUpload()
+
+	// The upload may have been completed or aborted while the part streamed.
+	if _, ok := o.checkUpload(w, r, bucketPath, uploadID, objectKey); !ok {
+		return
+	}
+
+	part := uploadPart{
+		PartNumber:   partNumber,
+		ETag:         hex.EncodeToString(hash.Sum(nil)),
+		Size:         size,
+		LastModified: time.Now(),
+	}
+
+	if err := os.Rename(tempPath, partPath(bucketPath, uploadID, partNumber)); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to save part"))
+		return
+	}
+
+	if err := putUploadPart(bucketPath, uploadID, part); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to record part"))
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(part.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *ObjectHandler) ListParts(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	if !o.checkBucket(w, r, bucketName) {
+		return
+	}
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	if _, ok := o.checkUpload(w, r, bucketPath, uploadID, objectKey); !ok {
+		return
+	}
+
+	maxParts := 1000
+	if value := query.Get("max-parts"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			WriteXMLError(w, r, ErrInvalidArgument.WithMessage("max-parts must be a non-negative integer"))
+			return
+		}
+		maxParts = min(n, 1000)
+	}
+
+	marker := 0
+	if value := query.Get("part-number-marker"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			WriteXMLError(w, r, ErrInvalidArgument.WithMessage("part-number-marker must be a non-negative integer"))
+			return
+		}
+		marker = n
+	}
+
+	parts, err := readUploadParts(bucketPath, uploadID)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read parts"))
+		return
+	}
+
+	response := ListPartsResult{
+		Bucket:           bucketName,
+		Key:              objectKey,
+		UploadID:         uploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
+		}
+		if len(response.Parts) == maxParts {
+			response.IsTruncated = true
+			break
+		}
+		response.Parts = append(response.Parts, PartEntry{
+			PartNumber:   part.PartNumber,
+			LastModified: part.LastModified.UTC().Format(s3TimeFormat),
+			ETag:         quoteETag(part.ETag),
+			Size:         part.Size,
+		})
+		response.NextPartNumberMarker = part.PartNumber
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (o *ObjectHandler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	uploadID := r.URL.Query().Get("uploadId")
+
+	var request CompleteMultipartUpload
+	if !decodeXMLBody(w, r, maxCompleteBody, &request, ErrMalformedXML) {
+		return
+	}
+	if len(request.Parts) == 0 {
+		WriteXMLError(w, r, ErrMalformedXML)
+		return
+	}
+	if len(request.Parts) > maxPartNumber {
+		WriteXMLError(w, r, ErrMalformedXML.WithMessage(fmt.Sprintf("A multipart upload can have at most %d parts", maxPartNumber)))
+		return
+	}
+
+	unlock, ok := o.lockBucket(w, r, bucketName)
+	if !ok {
+		return
+	}
+	defer unlock()
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+
+	unlockUpload, err := o.Locks.LockUpload(bucketName, uploadID)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock upload"))
+		return
+	}
+	defer unlockUpload()
+
+	upload, ok := o.checkUpload(w, r, bucketPath, uploadID, objectKey)
+	if !ok {
+		return
+	}
+
+	uploaded, err := readUploadParts(bucketPath, uploadID)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read parts"))
+		return
+	}
+	byNumber := make(map[int]uploadPart, len(uploaded))
+	for _, part := range uploaded {
+		byNumber[part.PartNumber] = part
+	}
+
+	selected := make([]uploadPart, 0, len(request.Parts))
+	for i, requested := range request.Parts {
+		if i > 0 && requested.PartNumber <= request.Parts[i-1].PartNumber {
+			WriteXMLError(w, r, ErrInvalidPartOrder)
+			return
+		}
+
+		part, found := byNumber[requested.PartNumber]
+		if !found || strings.Trim(requested.ETag, `"`) != part.ETag {
+			WriteXMLError(w, r, ErrInvalidPart.WithMessage(fmt.Sprintf("Part %d could not be found or its ETag does not match", requested.PartNumber)))
+			return
+		}
+
+		if i < len(request.Parts)-1 && part.Size < minPartSize {
+			WriteXMLError(w, r, ErrEntityTooSmall)
+			return
+		}
+		selected = append(selected, part)
+	}
+
+	tempPath, size, etag, err := assembleParts(bucketPath, uploadID, selected)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to assemble parts"))
+		return
+	}
+	defer os.Remove(tempPath)
+
+	object := upload.Object
+	object.Size = size
+	object.LastModified = time.Now()
+	object.ETag = etag
+
+	if !o.commitObject(w, r, bucketName, tempPath, object) {
+		return
+	}
+
+	os.RemoveAll(uploadDir(bucketPath, uploadID))
+
+	response := CompleteMultipartUploadResult{
+		Location: "/" + bucketName + "/" + objectKey,
+		Bucket:   bucketName,
+		Key:      objectKey,
+		ETag:     quoteETag(etag),
+	}
+
+	w.Header().Set("ETag", quoteETag(etag))
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func (o *ObjectHandler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	uploadID := r.URL.Query().Get("uploadId")
+
+	unlock, ok := o.lockBucket(w, r, bucketName)
+	if !ok {
+		return
+	}
+	defer unlock()
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+
+	unlockUpload, err := o.Locks.LockUpload(bucketName, uploadID)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock upload"))
+		return
+	}
+	defer unlockUpload()
+
+	if _, ok := o.checkUpload(w, r, bucketPath, uploadID, objectKey); !ok {
+		return
+	}
+
+	if err := os.RemoveAll(uploadDir(bucketPath, uploadID)); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to abort multipart upload"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *BucketHandler) ListMultipartUploads(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	if _, err := b.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+
+	query := r.URL.Query()
+	maxUploads := 1000
+	if value := query.Get("max-uploads"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			WriteXMLError(w, r, ErrInvalidArgument.WithMessage("max-uploads must be a non-negative integer"))
+			return
+		}
+		maxUploads = min(n, 1000)
+	}
+
+	uploads, err := listMultipartUploads(filepath.Join(b.BaseDir, bucketName))
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to list multipart uploads"))
+		return
+	}
+
+	response := ListMultipartUploadsResult{
+		Bucket:     bucketName,
+		KeyMarker:  query.Get("key-marker"),
+		Prefix:     query.Get("prefix"),
+		MaxUploads: maxUploads,
+	}
+	// As in S3, upload-id-marker only counts together with key-marker.
+	if response.KeyMarker != "" {
+		response.UploadIDMarker = query.Get("upload-id-marker")
+	}
+
+	for _, upload := range uploadsAfter(uploads, response.KeyMarker, response.UploadIDMarker) {
+		if !strings.HasPrefix(upload.Object.Key, response.Prefix) {
+			continue
+		}
+		if len(response.Uploads) == maxUploads {
+			// With max-uploads=0 the response only tells whether there is
+			// anything to list, and has no upload to continue from.
+			response.IsTruncated = true
+			if maxUploads > 0 {
+				last := response.Uploads[len(response.Uploads)-1]
+				response.NextKeyMarker, response.NextUploadIDMarker = last.Key, last.UploadID
+			}
+			break
+		}
+		response.Uploads = append(response.Uploads, UploadEntry{
+			Key:          upload.Object.Key,
+			UploadID:     upload.UploadID,
+			Initiated:    upload.Object.LastModified.UTC().Format(s3TimeFormat),
+			StorageClass: "STANDARD",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+// checkUpload looks up a multipart upload and makes sure it belongs to key.
+func (o *ObjectHandler) checkUpload(w http.ResponseWriter, r *http.Request, bucketPath, uploadID, key string) (multipartUpload, bool) {
+	upload, err := readMultipartUpload(bucketPath, uploadID)
+	if errors.Is(err, errNoSuchUpload) || (err == nil && upload.Object.Key != key) {
+		WriteXMLError(w, r, ErrNoSuchUpload)
+		return multipartUpload{}, false
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read multipart upload"))
+		return multipartUpload{}, false
+	}
+	return upload, true
+}
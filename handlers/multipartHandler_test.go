@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func listUploads(t *testing.T, h http.Handler, query url.Values) ListMultipartUploadsResult {
+	t.Helper()
+
+	w := do(t, h, http.MethodGet, "/"+testBucket+"?uploads&"+query.Encode(), "")
+	expectStatus(t, w, http.StatusOK, "ListMultipartUploads")
+	var result ListMultipartUploadsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestListMultipartUploadsPagesWithMarkers(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	for _, key := range []string{"a", "a", "b", "c"} {
+		expectStatus(t, do(t, h, http.MethodPost, "/"+testBucket+"/"+key+"?uploads", ""), http.StatusOK, "CreateMultipartUpload "+key)
+	}
+
+	all := listUploads(t, h, url.Values{})
+	if all.IsTruncated || len(all.Uploads) != 4 {
+		t.Fatalf("got %d uploads, truncated %v; want all 4", len(all.Uploads), all.IsTruncated)
+	}
+
+	var paged []UploadEntry
+	query := url.Values{"max-uploads": {"1"}}
+	for page := 0; ; page++ {
+		if page > len(all.Uploads) {
+			t.Fatal("listing does not end")
+		}
+		result := listUploads(t, h, query)
+		paged = append(paged, result.Uploads...)
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("upload-id-marker", result.NextUploadIDMarker)
+	}
+
+	if len(paged) != len(all.Uploads) {
+		t.Fatalf("paging returned %d uploads, want %d", len(paged), len(all.Uploads))
+	}
+	for i := range paged {
+		if paged[i].Key != all.Uploads[i].Key || paged[i].UploadID != all.Uploads[i].UploadID {
+			t.Fatalf("upload %d: got %s/%s, want %s/%s", i, paged[i].Key, paged[i].UploadID, all.Uploads[i].Key, all.Uploads[i].UploadID)
+		}
+	}
+}
+
+func TestListMultipartUploadsMaxUploadsZero(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+
+	query := url.Values{"max-uploads": {"0"}}
+	if result := listUploads(t, h, query); result.IsTruncated || len(result.Uploads) != 0 {
+		t.Fatalf("no uploads: got %d uploads, truncated %v; want none", len(result.Uploads), result.IsTruncated)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/"+testBucket+"/key?uploads", ""), http.StatusOK, "CreateMultipartUpload")
+	result := listUploads(t, h, query)
+	if !result.IsTruncated || len(result.Uploads) != 0 || result.NextKeyMarker != "" {
+		t.Fatalf("got %d uploads, truncated %v, next key marker %q; want none, truncated", len(result.Uploads), result.IsTruncated, result.NextKeyMarker)
+	}
+}
+
+func TestCompleteMultipartUploadLimitsTheRequest(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+
+	w := do(t, h, http.MethodPost, "/"+testBucket+"/key?uploads", "")
+	expectStatus(t, w, http.StatusOK, "CreateMultipartUpload")
+	var created InitiateMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	target := "/" + testBucket + "/key?uploadId=" + created.UploadID
+
+	var parts strings.Builder
+	for i := 1; i <= maxPartNumber+1; i++ {
+		fmt.Fprintf(&parts, "<Part><PartNumber>%d</PartNumber><ETag>\"etag\"</ETag></Part>", i)
+	}
+	for name, body := range map[string]string{
+		"too many parts": "<CompleteMultipartUpload>" + parts.String() + "</CompleteMultipartUpload>",
+		"oversized body": "<CompleteMultipartUpload>" + strings.Repeat(" ", maxCompleteBody) + "</CompleteMultipartUpload>",
+	} {
+		w := do(t, h, http.MethodPost, target, body)
+		expectStatus(t, w, http.StatusBadRequest, name)
+		if !strings.Contains(w.Body.String(), "MalformedXML") {
+			t.Fatalf("%s: got %s, want MalformedXML", name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"triple-s/store"
+	"triple-s/utils"
+)
+
+const (
+	maxPartNumber    = 10000
+	partFileTemplate = "part-%05d"
+	// minPartSize applies to every part except the last one.
+	minPartSize = 5 << 20
+	// maxCompleteBody bounds a CompleteMultipartUpload request, which lists
+	// at most maxPartNumber parts.
+	maxCompleteBody = 4 << 20
+)
+
+var (
+	errNoSuchUpload = errors.New("the specified multipart upload does not exist")
+	uploadIDRegex   = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// multipartUpload is staged under <bucket>/.triple-s-multipart/<uploadId>/,
+// with the upload itself described in upload.csv and its parts in parts.csv.
+// Object carries the key and the headers given when the upload was created;
+// its LastModified is the time the upload was initiated.
+type multipartUpload struct {
+	UploadID string
+	Object   store.Object
+}
+
+type uploadPart struct {
+	PartNumber   int
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+func newUploadID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func uploadDir(bucketPath, uploadID string) string {
+	return filepath.Join(bucketPath, utils.MultipartDir, uploadID)
+}
+
+func partPath(bucketPath, uploadID string, partNumber int) string {
+	return filepath.Join(uploadDir(bucketPath, uploadID), fmt.Sprintf(partFileTemplate, partNumber))
+}
+
+func createMultipartUpload(bucketPath string, upload multipartUpload) error {
+	dir := uploadDir(bucketPath, upload.UploadID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	return utils.WriteCSVFile(filepath.Join(dir, "upload.csv"), [][]string{store.ObjectToRecord(upload.Object)})
+}
+
+func readMultipartUpload(bucketPath, uploadID string) (multipartUpload, error) {
+	if !uploadIDRegex.MatchString(uploadID) {
+		return multipartUpload{}, errNoSuchUpload
+	}
+
+	records, err := utils.ReadCSVFile(filepath.Join(uploadDir(bucketPath, uploadID), "upload.csv"))
+	if os.IsNotExist(err) {
+		return multipartUpload{}, errNoSuchUpload
+	} else if err != nil {
+		return multipartUpload{}, err
+	}
+	if len(records) != 1 {
+		return multipartUpload{}, fmt.Errorf("malformed upload record for %s", uploadID)
+	}
+
+	object, err := store.ObjectFromRecord(records[0])
+	if err != nil {
+		return multipartUpload{}, err
+	}
+	return multipartUpload{UploadID: uploadID, Object: object}, nil
+}
+
+func listMultipartUploads(bucketPath string) ([]multipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(bucketPath, utils.MultipartDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var uploads []multipartUpload
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		upload, err := readMultipartUpload(bucketPath, entry.Name())
+		if errors.Is(err, errNoSuchUpload) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		a, b := uploads[i].Object, uploads[j].Object
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.Before(b.LastModified)
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	return uploads, nil
+}
+
+// uploadsAfter drops the uploads a listing that stopped at keyMarker and
+// uploadIDMarker has already returned. uploads must be in listing order.
+// When the marker upload is gone, the uploads of keyMarker with a greater
+// upload ID follow it, as S3 documents.
+func uploadsAfter(uploads []multipartUpload, keyMarker, uploadIDMarker string) []multipartUpload {
+	if keyMarker == "" {
+		return uploads
+	}
+
+	markerIndex := -1
+	for i, upload := range uploads {
+		if upload.Object.Key == keyMarker && upload.UploadID == uploadIDMarker {
+			markerIndex = i
+			break
+		}
+	}
+
+	var after []multipartUpload
+	for i, upload := range uploads {
+		key := upload.Object.Key
+		laterForMarkerKey := key == keyMarker && uploadIDMarker != "" &&
+			(markerIndex >= 0 && i > markerIndex || markerIndex < 0 && upload.UploadID > uploadIDMarker)
+		if key > keyMarker || laterForMarkerKey {
+			after = append(after, upload)
+		}
+	}
+	return after
+}
+
+func readUploadParts(bucketPath, uploadID string) ([]uploadPart, error) {
+	records, err := utils.ReadCSVFile(filepath.Join(uploadDir(bucketPath, uploadID), "parts.csv"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	parts := make([]uploadPart, 0, len(records))
+	for _, record := range records {
+		if len(record) < 4 {
+			return nil, fmt.Errorf("malformed part record: %v", record)
+		}
+		number, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		lastModified, err := time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, uploadPart{PartNumber: number, ETag: record[1], Size: size, LastModified: lastModified})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// putUploadPart records a part in parts.csv, replacing an earlier upload of
+// the same part number. The caller must hold the upload lock.
+func putUploadPart(bucketPath, uploadID string, part uploadPart) error {
+	parts, err := readUploadParts(bucketPath, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var records [][]string
+	for _, existing := range parts {
+		if existing.PartNumber != part.PartNumber {
+			records = append(records, partRecord(existing))
+		}
+	}
+	records = append(records, partRecord(part))
+
+	return utils.WriteCSVFile(filepath.Join(uploadDir(bucketPath, uploadID), "parts.csv"), records)
+}
+
+func partRecord(part uploadPart) []string {
+	return []string{
+		strconv.Itoa(part.PartNumber),
+		part.ETag,
+		strconv.FormatInt(part.Size, 10),
+		part.LastModified.Format(time.RFC3339),
+	}
+}
+
+// assembleParts concatenates the selected parts into a temporary file in the
+// bucket directory and returns its path, size and the multipart ETag: the
+// MD5 of the concatenated binary part digests, suffixed with the part count.
+func assembleParts(bucketPath, uploadID string, parts []uploadPart) (string, int64, string, error) {
+	readers := make([]io.Reader, 0, len(parts))
+	files := make([]*os.File, 0, len(parts))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	digests := md5.New()
+	for _, part := range parts {
+		file, err := os.Open(partPath(bucketPath, uploadID, part.PartNumber))
+		if err != nil {
+			return "", 0, "", err
+		}
+		files = append(files, file)
+		readers = append(readers, file)
+
+		digest, err := hex.DecodeString(part.ETag)
+		if err != nil {
+			return "", 0, "", err
+		}
+		digests.Write(digest)
+	}
+
+	tempPath, size, err := utils.WriteTempFile(bucketPath, utils.TempUploadPrefix+"*", io.MultiReader(readers...))
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	etag := fmt.Sprintf("%s-%d", hex.EncodeToString(digests.Sum(nil)), len(parts))
+	return tempPath, size, etag, nil
+}
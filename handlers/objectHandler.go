@@ -1,71 +1,87 @@
 package handlers
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"time"
+	"triple-s/store"
+	"triple-s/utils"
 )
 
 func (o *ObjectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		o.GetObject(w, r)
-	case http.MethodPut:
-		o.UploadObject(w, r)
-	case http.MethodDelete:
-		o.DeleteObject(w, r)
-	default:
-		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	rt, ok := classify(r)
+	if !ok || rt.object == nil {
+		WriteXMLError(w, r, ErrMethodNotAllowed)
+		return
+	}
+	if action := rt.actionFor(r); action != "" {
+		bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+		if !authorize(o.Store, w, r, bucketName, objectKey, r.URL.Query().Get("versionId"), action) {
+			return
+		}
 	}
+	rt.object(o, w, r)
 }
 
 func (o *ObjectHandler) UploadObject(w http.ResponseWriter, r *http.Request) {
 	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
-	bucketPath := filepath.Join(o.BaseDir, bucketName)
-
-	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
-		WriteXMLError(w, http.StatusNotFound, "Bucket does not exist")
+	if err := utils.ValidateObjectKey(objectKey); err != nil {
+		WriteXMLError(w, r, objectKeyError(err))
 		return
 	}
+	if r.ContentLength > maxUploadSize {
+		WriteXMLError(w, r, ErrEntityTooLarge)
+		return
+	}
+	// Chunked bodies carry no length up front, so the limit is enforced
+	// while the body is read as well.
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
-	objectPath := filepath.Join(bucketPath, objectKey)
-	file, err := os.Create(objectPath)
+	userMetadata, err := userMetadataFromRequest(r)
 	if err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Could not save object")
+		WriteXMLError(w, r, ErrMetadataTooLarge.WithMessage(err.Error()))
 		return
 	}
-	defer file.Close()
-
-	size, err := io.Copy(file, r.Body)
+	acl, err := aclFromRequest(r)
 	if err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to save object")
+		WriteXMLError(w, r, ErrInvalidArgument.WithMessage(err.Error()))
 		return
 	}
 
-	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	unlock, ok := o.lockBucket(w, r, bucketName)
+	if !ok {
+		return
 	}
+	defer unlock()
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
 
-	lastModified := time.Now()
-	objectMetadata := []string{
-		objectKey,
-		strconv.FormatInt(size, 10),
-		contentType,
-		lastModified.Format(time.RFC3339),
+	hash := md5.New()
+	tempPath, size, err := utils.WriteTempFile(bucketPath, utils.TempUploadPrefix+"*", io.TeeReader(r.Body, hash))
+	if err != nil {
+		writeBodyError(w, r, err, "Failed to save object")
+		return
 	}
+	defer os.Remove(tempPath)
 
-	if err := updateObjectMetadata(bucketPath, objectKey, objectMetadata); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to update object metadata")
-		return
+	object := store.Object{
+		Key:          objectKey,
+		Size:         size,
+		LastModified: time.Now(),
+		ETag:         hex.EncodeToString(hash.Sum(nil)),
+		UserMetadata: userMetadata,
+		ACL:          acl,
 	}
+	applyContentHeaders(r, &object)
 
-	if err := updateBucketMetadata(o.BaseDir, bucketName, lastModified, "active"); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to update bucket metadata")
+	if !o.commitObject(w, r, bucketName, tempPath, object) {
 		return
 	}
 
@@ -73,14 +89,10 @@ func (o *ObjectHandler) UploadObject(w http.ResponseWriter, r *http.Request) {
 		XMLName xml.Name `xml:"UploadObjectResponse"`
 		Object  Object   `xml:"Object"`
 	}{
-		Object: Object{
-			ObjectKey:    objectKey,
-			Size:         int(size),
-			ContentType:  contentType,
-			LastModified: lastModified,
-		},
+		Object: objectFromRecord(object),
 	}
 
+	w.Header().Set("ETag", quoteETag(object.ETag))
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 	xml.NewEncoder(w).Encode(response)
@@ -88,69 +100,439 @@ func (o *ObjectHandler) UploadObject(w http.ResponseWriter, r *http.Request) {
 
 func (o *ObjectHandler) GetObject(w http.ResponseWriter, r *http.Request) {
 	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
-	bucketPath := filepath.Join(o.BaseDir, bucketName)
+	if !o.checkBucket(w, r, bucketName) {
+		return
+	}
+
+	object, objectPath, err := lookupVersion(o.Store, o.BaseDir, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if err != nil {
+		WriteXMLError(w, r, versionLookupError(w, object, err))
+		return
+	}
 
-	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
-		WriteXMLError(w, http.StatusNotFound, "Bucket does not exist")
+	if status := checkPreconditions(r, object, true, true); status != http.StatusOK {
+		setValidatorHeaders(w, object)
+		if status == http.StatusNotModified {
+			w.WriteHeader(status)
+		} else {
+			WriteXMLError(w, r, ErrPreconditionFailed)
+		}
 		return
 	}
 
-	objectPath := filepath.Join(bucketPath, objectKey)
 	file, err := os.Open(objectPath)
 	if err != nil {
-		WriteXMLError(w, http.StatusNotFound, "Object not found")
+		WriteXMLError(w, r, ErrNoSuchKey)
 		return
 	}
 	defer file.Close()
 
-	contentType := getContentType(objectPath)
-	w.Header().Set("Content-Type", contentType)
+	info, err := file.Stat()
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to retrieve object"))
+		return
+	}
+	size := info.Size()
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := io.Copy(w, file); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to retrieve object")
+	contentType := resolveContentType(object, file)
+	setContentHeaders(w, object, contentType)
+	setVersionHeader(w, object)
+	w.Header().Set("Accept-Ranges", "bytes")
+	setValidatorHeaders(w, object)
+
+	var ranges []byteRange
+	if rangeApplies(r, w.Header().Get("ETag"), w.Header().Get("Last-Modified")) {
+		ranges, err = parseRange(r.Header.Get("Range"), size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			WriteXMLError(w, r, ErrInvalidRange.WithMessage(err.Error()))
+			return
+		}
+	}
+
+	if len(ranges) > 0 {
+		serveRanges(w, file, size, contentType, ranges)
+		return
 	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, file)
 }
 
-func (o *ObjectHandler) DeleteObject(w http.ResponseWriter, r *http.Request) {
+func (o *ObjectHandler) HeadObject(w http.ResponseWriter, r *http.Request) {
 	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
-	bucketPath := filepath.Join(o.BaseDir, bucketName)
 
-	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
-		WriteXMLError(w, http.StatusNotFound, "Bucket does not exist")
+	if _, err := o.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	objectPath := filepath.Join(bucketPath, objectKey)
-	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
-		WriteXMLError(w, http.StatusNotFound, "Object not found")
+	object, objectPath, err := lookupVersion(o.Store, o.BaseDir, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if err != nil {
+		w.WriteHeader(versionLookupError(w, object, err).StatusCode)
 		return
 	}
 
-	if err := os.Remove(objectPath); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to delete object")
+	if status := checkPreconditions(r, object, true, true); status != http.StatusOK {
+		setValidatorHeaders(w, object)
+		w.WriteHeader(status)
 		return
 	}
 
-	if err := removeObjectMetadata(bucketPath, objectKey); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to update object metadata")
+	file, err := os.Open(objectPath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	defer file.Close()
 
-	empty, err := isBucketEmpty(bucketPath)
-	if err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to verify bucket status")
+	setObjectHeaders(w, object, resolveContentType(object, file))
+	setVersionHeader(w, object)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *ObjectHandler) DeleteObject(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	versionID := r.URL.Query().Get("versionId")
+	unlock, ok := o.lockObject(w, r, bucketName, objectKey)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	newStatus := "active"
-	if empty {
-		newStatus = "marked for deletion"
+	if versionID == "" {
+		object, err := o.Store.GetObject(bucketName, objectKey)
+		if err != nil && !errors.Is(err, store.ErrObjectNotFound) {
+			WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+			return
+		}
+		if status := checkPreconditions(r, object, err == nil, false); status != http.StatusOK {
+			WriteXMLError(w, r, ErrPreconditionFailed)
+			return
+		}
 	}
-	if err := updateBucketMetadata(o.BaseDir, bucketName, time.Now(), newStatus); err != nil {
-		WriteXMLError(w, http.StatusInternalServerError, "Failed to update bucket metadata")
+
+	result, err := o.removeObject(bucketName, objectKey, versionID)
+	switch {
+	case errors.Is(err, errNoSuchKey):
+		WriteXMLError(w, r, ErrNoSuchKey)
+		return
+	case errors.Is(err, errNoSuchVersion):
+		WriteXMLError(w, r, ErrNoSuchVersion)
+		return
+	case err != nil:
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to delete object"))
 		return
 	}
 
+	if result.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", result.VersionID)
+	}
+	if result.DeleteMarker {
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (o *ObjectHandler) checkBucket(w http.ResponseWriter, r *http.Request, bucketName string) bool {
+	if _, err := o.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return false
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return false
+	}
+	return true
+}
+
+// commitObject records object in the metadata and moves the fully written
+// temporary file into place, evaluating the request's preconditions against
+// the object it replaces. The caller must hold the shared bucket lock. On
+// failure an error response has already been written.
+func (o *ObjectHandler) commitObject(w http.ResponseWriter, r *http.Request, bucketName, tempPath string, object store.Object) bool {
+	unlockObject, err := o.Locks.LockObject(bucketName, object.Key)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock object"))
+		return false
+	}
+	defer unlockObject()
+
+	unlockMetadata, err := o.Locks.LockBucketMetadata(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock bucket metadata"))
+		return false
+	}
+	defer unlockMetadata()
+
+	previous, previousErr := o.Store.GetObject(bucketName, object.Key)
+	if previousErr != nil && !errors.Is(previousErr, store.ErrObjectNotFound) {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+		return false
+	}
+
+	if status := checkPreconditions(r, previous, previousErr == nil, false); status != http.StatusOK {
+		WriteXMLError(w, r, ErrPreconditionFailed)
+		return false
+	}
+
+	bucket, err := o.Store.GetBucket(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return false
+	}
+
+	object.VersionID, err = newVersionID(bucket.Versioning)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to generate version ID"))
+		return false
+	}
+
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+	if bucket.Versioning == store.VersioningSuspended {
+		if err := removeNullVersion(o.Store, bucketPath, bucketName, object.Key); err != nil {
+			WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update object versions"))
+			return false
+		}
+	}
+
+	// In a versioned bucket the object being replaced becomes a noncurrent
+	// version, unless it is the "null" version a suspended bucket overwrites.
+	var archived store.Object
+	isArchived := false
+	if previousErr == nil && bucket.Versioning != "" &&
+		!(bucket.Versioning == store.VersioningSuspended && isNullVersion(previous.VersionID)) {
+		archived, err = archiveCurrent(o.Store, bucketPath, bucketName, previous)
+		if err != nil {
+			WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to archive previous version"))
+			return false
+		}
+		isArchived = true
+	}
+
+	rollback := func() {
+		switch {
+		case isArchived:
+			restoreArchived(o.Store, bucketPath, bucketName, previous, archived)
+		case previousErr == nil:
+			o.Store.PutObject(bucketName, previous)
+		default:
+			o.Store.DeleteObject(bucketName, object.Key)
+		}
+	}
+
+	if err := o.Store.PutObject(bucketName, object); err != nil {
+		rollback()
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update object metadata"))
+		return false
+	}
+
+	if err := commitObjectFile(tempPath, objectPath(bucketPath, object.Key)); err != nil {
+		rollback()
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to save object"))
+		return false
+	}
+
+	if err := updateBucketStatus(o.Store, o.Locks, bucketName, object.LastModified); err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to update bucket metadata"))
+		return false
+	}
+
+	setVersionHeader(w, object)
+	return true
+}
+
+type removeResult struct {
+	VersionID    string
+	DeleteMarker bool
+}
+
+// removeObject deletes key, or one version of it when versionID is set. In a
+// versioned bucket deleting without a version ID archives the current
+// version and places a delete marker on top. The caller must hold the shared
+// bucket lock and the object lock.
+func (o *ObjectHandler) removeObject(bucketName, key, versionID string) (removeResult, error) {
+	unlockMetadata, err := o.Locks.LockBucketMetadata(bucketName)
+	if err != nil {
+		return removeResult{}, err
+	}
+	defer unlockMetadata()
+
+	bucket, err := o.Store.GetBucket(bucketName)
+	if err != nil {
+		return removeResult{}, err
+	}
+
+	result, err := o.removeObjectLocked(bucket, key, versionID)
+	if err != nil {
+		return removeResult{}, err
+	}
+	return result, updateBucketStatus(o.Store, o.Locks, bucketName, time.Now())
+}
+
+// removeObjectLocked is removeObject for callers that already hold the bucket
+// metadata lock and refresh the bucket status themselves.
+func (o *ObjectHandler) removeObjectLocked(bucket store.Bucket, key, versionID string) (removeResult, error) {
+	bucketName := bucket.Name
+	bucketPath := filepath.Join(o.BaseDir, bucketName)
+
+	current, err := o.Store.GetObject(bucketName, key)
+	if err != nil && !errors.Is(err, store.ErrObjectNotFound) {
+		return removeResult{}, err
+	}
+	exists := err == nil
+
+	var result removeResult
+	switch {
+	case versionID != "":
+		result, err = o.removeVersion(bucketPath, bucketName, key, versionID, current, exists)
+	case bucket.Versioning == "":
+		if !exists {
+			return removeResult{}, errNoSuchKey
+		}
+		err = o.removeCurrent(bucketPath, bucketName, current)
+	default:
+		result, err = o.placeDeleteMarker(bucket, bucketPath, key, current, exists)
+	}
+	return result, err
+}
+
+// removeCurrent deletes the current version of an object. Its data is only
+// removed once the metadata no longer refers to it.
+func (o *ObjectHandler) removeCurrent(bucketPath, bucketName string, current store.Object) error {
+	path := objectPath(bucketPath, current.Key)
+	trashPath, err := trashFile(bucketPath, path)
+	if err != nil {
+		return err
+	}
+	if err := o.Store.DeleteObject(bucketName, current.Key); err != nil {
+		restoreFile(trashPath, path)
+		return err
+	}
+	emptyTrash(trashPath)
+	return nil
+}
+
+func (o *ObjectHandler) placeDeleteMarker(bucket store.Bucket, bucketPath, key string, current store.Object, exists bool) (removeResult, error) {
+	if exists {
+		if bucket.Versioning == store.VersioningSuspended && isNullVersion(current.VersionID) {
+			if err := o.removeCurrent(bucketPath, bucket.Name, current); err != nil {
+				return removeResult{}, err
+			}
+		} else {
+			if _, err := archiveCurrent(o.Store, bucketPath, bucket.Name, current); err != nil {
+				return removeResult{}, err
+			}
+			if err := o.Store.DeleteObject(bucket.Name, key); err != nil {
+				return removeResult{}, err
+			}
+		}
+	}
+
+	if bucket.Versioning == store.VersioningSuspended {
+		if err := removeNullVersion(o.Store, bucketPath, bucket.Name, key); err != nil {
+			return removeResult{}, err
+		}
+	}
+
+	markerID, err := newVersionID(bucket.Versioning)
+	if err != nil {
+		return removeResult{}, err
+	}
+
+	marker := store.Object{
+		Key:          key,
+		LastModified: time.Now(),
+		VersionID:    markerID,
+		DeleteMarker: true,
+	}
+	if err := o.Store.PutVersion(bucket.Name, marker); err != nil {
+		return removeResult{}, err
+	}
+	return removeResult{VersionID: markerID, DeleteMarker: true}, nil
+}
+
+func (o *ObjectHandler) removeVersion(bucketPath, bucketName, key, versionID string, current store.Object, exists bool) (removeResult, error) {
+	if exists && versionMatches(current.VersionID, versionID) {
+		if err := o.removeCurrent(bucketPath, bucketName, current); err != nil {
+			return removeResult{}, err
+		}
+		if err := promoteLatestVersion(o.Store, bucketPath, bucketName, key); err != nil {
+			return removeResult{}, err
+		}
+		return removeResult{VersionID: versionID}, nil
+	}
+
+	versions, err := o.Store.ListVersions(bucketName)
+	if err != nil {
+		return removeResult{}, err
+	}
+
+	for _, version := range versionsOf(versions, key) {
+		if version.VersionID != versionID {
+			continue
+		}
+
+		var trashPath string
+		path := versionPath(bucketPath, key, versionID)
+		if !version.DeleteMarker {
+			if trashPath, err = trashFile(bucketPath, path); err != nil {
+				return removeResult{}, err
+			}
+		}
+		if err := o.Store.DeleteVersion(bucketName, key, versionID); err != nil {
+			restoreFile(trashPath, path)
+			return removeResult{}, err
+		}
+		emptyTrash(trashPath)
+		pruneVersionDir(bucketPath, key)
+		if err := promoteLatestVersion(o.Store, bucketPath, bucketName, key); err != nil {
+			return removeResult{}, err
+		}
+		return removeResult{VersionID: versionID, DeleteMarker: version.DeleteMarker}, nil
+	}
+	return removeResult{}, errNoSuchVersion
+}
+
+// lockBucket takes the shared bucket lock, verifying that the bucket still
+// exists once the lock is held.
+func (o *ObjectHandler) lockBucket(w http.ResponseWriter, r *http.Request, bucketName string) (func(), bool) {
+	if !o.checkBucket(w, r, bucketName) {
+		return nil, false
+	}
+
+	unlock, err := o.Locks.RLockBucket(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock bucket"))
+		return nil, false
+	}
+
+	if !o.checkBucket(w, r, bucketName) {
+		unlock()
+		return nil, false
+	}
+	return unlock, true
+}
+
+// lockObject takes the shared bucket lock and the object lock.
+func (o *ObjectHandler) lockObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) (func(), bool) {
+	unlockBucket, ok := o.lockBucket(w, r, bucketName)
+	if !ok {
+		return nil, false
+	}
+
+	unlockObject, err := o.Locks.LockObject(bucketName, objectKey)
+	if err != nil {
+		unlockBucket()
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to lock object"))
+		return nil, false
+	}
+
+	return func() {
+		unlockObject()
+		unlockBucket()
+	}, true
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"triple-s/locks"
+	"triple-s/store"
+	"triple-s/utils"
+)
+
+const (
+	testBucket    = "test-bucket"
+	testAccessKey = "AKIDTEST"
+)
+
+// newTestServer routes requests the way main does, over metadata kept in a
+// MemoryStore and object data in a temporary directory.
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+
+	lockManager, err := locks.NewManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newServer(t.TempDir(), store.NewMemoryStore(), lockManager)
+}
+
+// newServer routes requests the way main does.
+func newServer(baseDir string, metadata store.MetadataStore, lockManager *locks.Manager) http.Handler {
+	bucketHandler := &BucketHandler{BaseDir: baseDir, Store: metadata, Locks: lockManager}
+	objectHandler := &ObjectHandler{BaseDir: baseDir, Store: metadata, Locks: lockManager}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", bucketHandler)
+	mux.Handle("/{bucket}", bucketHandler)
+	mux.Handle("/{bucket}/", bucketHandler)
+	mux.Handle("/{bucket}/{object}", objectHandler)
+	mux.Handle("/{bucket}/{object}/", objectHandler)
+	return mux
+}
+
+// do serves a request signed by testAccessKey, as AuthHandler would pass it
+// on once the signature checked out.
+func do(t *testing.T, h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(h, newRequest(method, target, body, header...), testAccessKey)
+}
+
+// doAnonymous serves an unsigned request to a server that checks signatures.
+func doAnonymous(t *testing.T, h http.Handler, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(h, newRequest(method, target, ""), "")
+}
+
+// newRequest builds a request with header given as name, value pairs.
+func newRequest(method, target, body string, header ...string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return r
+}
+
+func serve(h http.Handler, r *http.Request, accessKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, accessKey)))
+	return w
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, want int, what string) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("%s: got status %d, want %d: %s", what, w.Code, want, w.Body.String())
+	}
+}
+
+func createBucket(t *testing.T, h http.Handler, header ...string) {
+	t.Helper()
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket, "", header...), http.StatusOK, "create bucket")
+}
+
+func expectObject(t *testing.T, h http.Handler, key, want string) {
+	t.Helper()
+	w := do(t, h, http.MethodGet, "/"+testBucket+"/"+key, "")
+	expectStatus(t, w, http.StatusOK, "GET "+key)
+	if body, _ := io.ReadAll(w.Body); string(body) != want {
+		t.Fatalf("GET %s: got %q, want %q", key, body, want)
+	}
+}
+
+func TestPutGetRoundTrip(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/notes.txt", "hello", "Content-Type", "text/plain")
+	expectStatus(t, w, http.StatusOK, "PUT")
+	expectObject(t, h, "notes.txt", "hello")
+
+	w = do(t, h, http.MethodHead, "/"+testBucket+"/notes.txt", "")
+	expectStatus(t, w, http.StatusOK, "HEAD")
+	if got := w.Header().Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("HEAD: got Content-Type %q, want text/plain", got)
+	}
+
+	expectStatus(t, do(t, h, http.MethodDelete, "/"+testBucket+"/notes.txt", ""), http.StatusNoContent, "DELETE")
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/notes.txt", ""), http.StatusNotFound, "GET after DELETE")
+}
+
+// A key may be both an object and the prefix of other keys, in either order.
+func TestNestedKeysDoNotClash(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+
+	for _, step := range []struct{ method, key, body string }{
+		{http.MethodPut, "a/b", "a/b"},
+		{http.MethodDelete, "a/b", ""},
+		{http.MethodPut, "a", "a"},
+		{http.MethodPut, "c", "c"},
+		{http.MethodPut, "c/d", "c/d"},
+	} {
+		w := do(t, h, step.method, "/"+testBucket+"/"+step.key, step.body)
+		if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
+			t.Fatalf("%s %s: got status %d: %s", step.method, step.key, w.Code, w.Body.String())
+		}
+	}
+
+	expectObject(t, h, "a", "a")
+	expectObject(t, h, "c", "c")
+	expectObject(t, h, "c/d", "c/d")
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/a/b", ""), http.StatusNotFound, "GET a/b")
+}
+
+// HEAD reads an object whatever the query string says, so anonymous requests
+// need read access to the object itself.
+func TestAnonymousHeadNeedsReadAccess(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h, "X-Amz-Acl", "public-read-write")
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/private", "secret"), http.StatusOK, "PUT private")
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/public", "shared", "X-Amz-Acl", "public-read"), http.StatusOK, "PUT public")
+
+	for _, target := range []string{"/private", "/private?uploadId=none", "/private?acl"} {
+		w := doAnonymous(t, h, http.MethodHead, "/"+testBucket+target)
+		expectStatus(t, w, http.StatusForbidden, "anonymous HEAD "+target)
+	}
+	expectStatus(t, doAnonymous(t, h, http.MethodHead, "/"+testBucket+"/public"), http.StatusOK, "anonymous HEAD /public")
+}
+
+func TestConditionalRequests(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/key", "data")
+	expectStatus(t, w, http.StatusOK, "PUT")
+	etag := w.Header().Get("ETag")
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header []string
+		status int
+	}{
+		{"If-Match", []string{"If-Match", etag}, http.StatusOK},
+		{"If-Match in a list", []string{"If-Match", `"other", ` + etag}, http.StatusOK},
+		{"If-Match *", []string{"If-Match", "*"}, http.StatusOK},
+		{"If-Match fails", []string{"If-Match", `"other"`}, http.StatusPreconditionFailed},
+		{"If-None-Match", []string{"If-None-Match", `"other"`}, http.StatusOK},
+		{"If-None-Match fails", []string{"If-None-Match", etag}, http.StatusNotModified},
+		{"If-None-Match weak", []string{"If-None-Match", "W/" + etag}, http.StatusNotModified},
+		{"If-Modified-Since", []string{"If-Modified-Since", past}, http.StatusOK},
+		{"If-Modified-Since fails", []string{"If-Modified-Since", future}, http.StatusNotModified},
+		{"If-Unmodified-Since", []string{"If-Unmodified-Since", future}, http.StatusOK},
+		{"If-Unmodified-Since fails", []string{"If-Unmodified-Since", past}, http.StatusPreconditionFailed},
+		{"If-Match overrides If-Unmodified-Since", []string{"If-Match", etag, "If-Unmodified-Since", past}, http.StatusOK},
+		{"If-None-Match overrides If-Modified-Since", []string{"If-None-Match", `"other"`, "If-Modified-Since", future}, http.StatusOK},
+		{"failed If-Match beats failed If-None-Match", []string{"If-Match", `"other"`, "If-None-Match", etag}, http.StatusPreconditionFailed},
+		{"If-Match then failed If-None-Match", []string{"If-Match", etag, "If-None-Match", etag}, http.StatusNotModified},
+		{"failed If-Unmodified-Since beats failed If-Modified-Since", []string{"If-Unmodified-Since", past, "If-Modified-Since", future}, http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range tests {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			w := do(t, h, method, "/"+testBucket+"/key", "", tc.header...)
+			expectStatus(t, w, tc.status, method+" "+tc.name)
+			if tc.status == http.StatusNotModified {
+				if w.Header().Get("ETag") != etag || w.Body.Len() != 0 {
+					t.Fatalf("%s %s: got ETag %q and body %q, want ETag %s and no body", method, tc.name, w.Header().Get("ETag"), w.Body.String(), etag)
+				}
+			}
+		}
+	}
+}
+
+// Writes answer 412 where reads would answer 304, and ignore the
+// modification dates S3 only honors on reads.
+func TestConditionalWrites(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/key", "data"), http.StatusOK, "PUT")
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		header []string
+		status int
+	}{
+		{"create only if absent", http.MethodPut, "key", []string{"If-None-Match", "*"}, http.StatusPreconditionFailed},
+		{"create new key only if absent", http.MethodPut, "new", []string{"If-None-Match", "*"}, http.StatusOK},
+		{"replace a missing key", http.MethodPut, "missing", []string{"If-Match", "*"}, http.StatusPreconditionFailed},
+		{"replace a changed object", http.MethodPut, "key", []string{"If-Match", `"other"`}, http.StatusPreconditionFailed},
+		{"If-Modified-Since is ignored", http.MethodPut, "key", []string{"If-Modified-Since", future}, http.StatusOK},
+		{"delete a changed object", http.MethodDelete, "key", []string{"If-Match", `"other"`}, http.StatusPreconditionFailed},
+		{"delete only if absent", http.MethodDelete, "key", []string{"If-None-Match", "*"}, http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range tests {
+		w := do(t, h, tc.method, "/"+testBucket+"/"+tc.key, "replaced", tc.header...)
+		expectStatus(t, w, tc.status, tc.name)
+		if tc.status == http.StatusPreconditionFailed && !strings.Contains(w.Body.String(), "PreconditionFailed") {
+			t.Fatalf("%s: got %s, want PreconditionFailed", tc.name, w.Body.String())
+		}
+	}
+	expectObject(t, h, "key", "replaced")
+}
+
+// Concurrent PUTs and DELETEs through two servers sharing a data directory,
+// as two processes would, over CSV files rewritten on every change: no
+// metadata row may be lost, and the object files must match the rows.
+func TestConcurrentWritesKeepFilesAndRowsInStep(t *testing.T) {
+	const (
+		writers       = 8
+		keysPerWriter = 20
+	)
+
+	baseDir := t.TempDir()
+	var servers []http.Handler
+	for i := 0; i < 2; i++ {
+		lockManager, err := locks.NewManager(filepath.Join(baseDir, ".locks"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, newServer(baseDir, store.NewCSVStore(baseDir), lockManager))
+	}
+	createBucket(t, servers[0])
+
+	// Every writer puts its keys, overwrites them and deletes every other
+	// one, so the rows of all writers are rewritten concurrently.
+	var wg sync.WaitGroup
+	failures := make(chan string, writers*keysPerWriter*3)
+	for w := 0; w < writers; w++ {
+		h := servers[w%len(servers)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				target := fmt.Sprintf("/%s/w%d/%02d", testBucket, w, i)
+				steps := []struct{ method, body string }{
+					{http.MethodPut, "first"},
+					{http.MethodPut, target},
+				}
+				if i%2 == 1 {
+					steps = append(steps, struct{ method, body string }{http.MethodDelete, ""})
+				}
+				for _, step := range steps {
+					rec := serve(h, newRequest(step.method, target, step.body), testAccessKey)
+					if rec.Code != http.StatusOK && rec.Code != http.StatusNoContent {
+						failures <- fmt.Sprintf("%s %s: status %d: %s", step.method, target, rec.Code, rec.Body.String())
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(failures)
+	for failure := range failures {
+		t.Fatal(failure)
+	}
+
+	want := make(map[string]bool)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keysPerWriter; i += 2 {
+			want[fmt.Sprintf("w%d/%02d", w, i)] = true
+		}
+	}
+
+	objects, err := store.NewCSVStore(baseDir).ListObjects(testBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]bool)
+	for _, object := range objects {
+		rows[object.Key] = true
+	}
+	if len(objects) != len(want) || len(rows) != len(want) {
+		t.Fatalf("got %d object rows for %d keys, want %d", len(objects), len(rows), len(want))
+	}
+
+	files := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(baseDir, testBucket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "objects.csv" {
+			files[entry.Name()] = true
+		}
+	}
+	for key := range want {
+		if !rows[key] {
+			t.Fatalf("row of %s is missing", key)
+		}
+		if !files[utils.ObjectFileName(key)] {
+			t.Fatalf("file of %s is missing", key)
+		}
+		delete(files, utils.ObjectFileName(key))
+		expectObject(t, servers[1], key, "/"+testBucket+"/"+key)
+	}
+	if len(files) != 0 {
+		t.Fatalf("files without a row: %v", files)
+	}
+}
@@ -1,10 +1,19 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"triple-s/store"
 	"triple-s/utils"
 )
 
@@ -16,114 +25,342 @@ func parseBucketAndObject(path string) (string, string) {
 	return parts[0], parts[1]
 }
 
-func updateObjectMetadata(bucketPath, objectKey string, metadata []string) error {
-	metadataFile := filepath.Join(bucketPath, "objects.csv")
-	records, err := utils.ReadCSVFile(metadataFile)
-	if err != nil && !os.IsNotExist(err) {
-		return err
+func objectFromRecord(record store.Object) Object {
+	return Object{
+		ObjectKey:    record.Key,
+		Size:         int(record.Size),
+		ContentType:  record.ContentType,
+		LastModified: record.LastModified,
+		ETag:         quoteETag(record.ETag),
 	}
+}
+
+// applyContentHeaders copies the representation headers a client may set at
+// upload time onto object.
+func applyContentHeaders(r *http.Request, object *store.Object) {
+	object.ContentType = r.Header.Get("Content-Type")
+	object.ContentEncoding = r.Header.Get("Content-Encoding")
+	object.ContentDisposition = r.Header.Get("Content-Disposition")
+	object.CacheControl = r.Header.Get("Cache-Control")
+	object.Expires = r.Header.Get("Expires")
+}
+
+const (
+	userMetadataPrefix = "X-Amz-Meta-"
+	// maxUserMetadataSize is the S3 limit on the combined size of the names
+	// and values of all user-defined metadata.
+	maxUserMetadataSize = 2048
+	// maxUploadSize is the S3 limit on the body of a single PUT or part.
+	maxUploadSize = 5 << 30
+)
+
+// objectKeyError maps a utils.ValidateObjectKey failure to its S3 error.
+func objectKeyError(err error) APIError {
+	if errors.Is(err, utils.ErrKeyTooLong) {
+		return ErrKeyTooLong
+	}
+	return ErrInvalidArgument.WithMessage(err.Error())
+}
 
-	var updatedRecords [][]string
-	found := false
+// userMetadataFromRequest collects the x-amz-meta-* headers of a request.
+func userMetadataFromRequest(r *http.Request) (map[string]string, error) {
+	var metadata map[string]string
+	total := 0
+	for name, values := range r.Header {
+		suffix, ok := strings.CutPrefix(name, userMetadataPrefix)
+		if !ok || suffix == "" {
+			continue
+		}
 
-	for _, record := range records {
-		if record[0] == objectKey {
-			updatedRecords = append(updatedRecords, metadata) 
-			found = true
-		} else {
-			updatedRecords = append(updatedRecords, record)
+		if metadata == nil {
+			metadata = make(map[string]string)
 		}
+		key := strings.ToLower(suffix)
+		value := strings.Join(values, ",")
+		metadata[key] = value
+		total += len(key) + len(value)
 	}
 
-	if !found {
-		updatedRecords = append(updatedRecords, metadata) 
+	if total > maxUserMetadataSize {
+		return nil, fmt.Errorf("your metadata headers exceed the maximum allowed metadata size of %d bytes", maxUserMetadataSize)
 	}
+	return metadata, nil
+}
+
+// setContentHeaders returns the stored representation headers, leaving out
+// the ones that were never supplied.
+func setContentHeaders(w http.ResponseWriter, object store.Object, contentType string) {
+	w.Header().Set("Content-Type", contentType)
 
-	return utils.WriteCSVFile(metadataFile, updatedRecords)
+	stored := map[string]string{
+		"Content-Encoding":    object.ContentEncoding,
+		"Content-Disposition": object.ContentDisposition,
+		"Cache-Control":       object.CacheControl,
+		"Expires":             object.Expires,
+	}
+	for name, value := range stored {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+
+	for name, value := range object.UserMetadata {
+		w.Header().Set(userMetadataPrefix+name, value)
+	}
 }
 
-func updateBucketLastModified(baseDir, bucketName string, lastModified time.Time) error {
-	csvPath := filepath.Join(baseDir, "buckets.csv")
-	records, err := utils.ReadCSVFile(csvPath)
-	if err != nil && !os.IsNotExist(err) {
-		return err
+// resolveContentType returns the stored content type. Objects uploaded
+// without one fall back to the system MIME registry by extension and then
+// to sniffing the first bytes of content. The file offset is restored.
+func resolveContentType(object store.Object, file *os.File) string {
+	if object.ContentType != "" {
+		return object.ContentType
 	}
 
-	var updatedRecords [][]string
-	for _, record := range records {
-		if record[0] == bucketName {
-			record[2] = lastModified.Format(time.RFC3339) 
+	if byExtension := mime.TypeByExtension(filepath.Ext(object.Key)); byExtension != "" {
+		return byExtension
+	}
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	file.Seek(0, io.SeekStart)
+	if n == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// setObjectHeaders describes a stored object in the response headers, as
+// returned by HEAD.
+func setObjectHeaders(w http.ResponseWriter, object store.Object, contentType string) {
+	setContentHeaders(w, object, contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	setValidatorHeaders(w, object)
+}
+
+func setValidatorHeaders(w http.ResponseWriter, object store.Object) {
+	w.Header().Set("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	if object.ETag != "" {
+		w.Header().Set("ETag", quoteETag(object.ETag))
+	}
+}
+
+func quoteETag(etag string) string {
+	if etag == "" {
+		return ""
+	}
+	return `"` + etag + `"`
+}
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since against the current state of an object, following
+// the order of RFC 9110 section 13.2.2. It returns http.StatusOK when the
+// request may proceed. Failed If-None-Match and If-Modified-Since checks
+// answer 304 on reads and 412 on writes.
+func checkPreconditions(r *http.Request, object store.Object, exists, isRead bool) int {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !exists || !etagMatches(ifMatch, object.ETag) {
+			return http.StatusPreconditionFailed
 		}
-		updatedRecords = append(updatedRecords, record)
+	} else if since, ok := parseHTTPDate(r.Header.Get("If-Unmodified-Since")); ok && exists {
+		if object.LastModified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	notModified := http.StatusPreconditionFailed
+	if isRead {
+		notModified = http.StatusNotModified
 	}
 
-	return utils.WriteCSVFile(csvPath, updatedRecords)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if exists && etagMatches(ifNoneMatch, object.ETag) {
+			return notModified
+		}
+	} else if since, ok := parseHTTPDate(r.Header.Get("If-Modified-Since")); ok && exists && isRead {
+		if !object.LastModified.Truncate(time.Second).After(since) {
+			return notModified
+		}
+	}
+
+	return http.StatusOK
+}
+
+// etagMatches reports whether a comma separated list of entity tags from a
+// conditional header matches etag. Weak tags compare by their opaque value.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if etag != "" && strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
 }
 
-func updateBucketMetadata(baseDir, bucketName string, lastModified time.Time, status string) error {
-	csvPath := filepath.Join(baseDir, "buckets.csv")
-	records, err := utils.ReadCSVFile(csvPath)
-	if err != nil && !os.IsNotExist(err) {
+// objectPath is where the data of the current version of key lives.
+func objectPath(bucketPath, key string) string {
+	return filepath.Join(bucketPath, utils.ObjectFileName(key))
+}
+
+// commitObjectFile moves a fully written temporary file into place.
+func commitObjectFile(tempPath, objectPath string) error {
+	if err := os.Rename(tempPath, objectPath); err != nil {
 		return err
 	}
+	return utils.SyncDir(filepath.Dir(objectPath))
+}
 
-	var updatedRecords [][]string
-	for _, record := range records {
-		if record[0] == bucketName {
-			record[2] = lastModified.Format(time.RFC3339) 
-			record[3] = status
+// trashFile moves the data at path aside under a temporary name in
+// bucketPath ahead of the metadata update that deletes it, so that a failed
+// update can put it back with restoreFile. The caller removes the returned
+// file once the update is committed; one left by a crash is swept up with
+// the orphaned uploads. A missing file yields an empty trash path.
+func trashFile(bucketPath, path string) (string, error) {
+	file, err := os.CreateTemp(bucketPath, utils.TempUploadPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	trashPath := file.Name()
+	file.Close()
+
+	if err := os.Rename(path, trashPath); err != nil {
+		os.Remove(trashPath)
+		if os.IsNotExist(err) {
+			return "", nil
 		}
-		updatedRecords = append(updatedRecords, record)
-	}
-
-	return utils.WriteCSVFile(csvPath, updatedRecords)
-}
-
-func getContentType(filePath string) string {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	switch ext {
-	case ".png":
-		return "image/png"
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".gif":
-		return "image/gif"
-	case ".txt":
-		return "text/plain"
-	case ".pdf":
-		return "application/pdf"
-	default:
-		return "application/octet-stream"
+		return "", err
+	}
+	// The orphan sweep goes by modification time, which the data keeps.
+	now := time.Now()
+	os.Chtimes(trashPath, now, now)
+	return trashPath, nil
+}
+
+// restoreFile undoes trashFile.
+func restoreFile(trashPath, path string) {
+	if trashPath != "" {
+		os.Rename(trashPath, path)
 	}
 }
 
-func removeObjectMetadata(bucketPath, objectKey string) error {
-	metadataFile := filepath.Join(bucketPath, "objects.csv")
-	records, err := utils.ReadCSVFile(metadataFile)
+func emptyTrash(trashPath string) {
+	if trashPath != "" {
+		os.Remove(trashPath)
+	}
+}
+
+// MigrateObjectFiles moves data stored before keys mapped to a single file
+// name (see utils.ObjectFileName) to where objectPath expects it, and
+// removes the directories nested keys used to need.
+func MigrateObjectFiles(baseDir string, metadata store.MetadataStore) error {
+	buckets, err := metadata.ListBuckets()
 	if err != nil {
 		return err
 	}
 
-	var updatedRecords [][]string
-	for _, record := range records {
-		if record[0] != objectKey { 
-			updatedRecords = append(updatedRecords, record)
+	for _, bucket := range buckets {
+		bucketPath := filepath.Join(baseDir, bucket.Name)
+		objects, err := metadata.ListObjects(bucket.Name)
+		if err != nil {
+			return err
 		}
-	}
 
-	return utils.WriteCSVFile(metadataFile, updatedRecords)
+		// The old file of a key containing "%" can carry the new name of
+		// another key, so those move out of the way first.
+		sort.SliceStable(objects, func(i, j int) bool {
+			return strings.Contains(objects[i].Key, "%") && !strings.Contains(objects[j].Key, "%")
+		})
+		for _, object := range objects {
+			legacy := filepath.Join(bucketPath, filepath.FromSlash(object.Key))
+			current := objectPath(bucketPath, object.Key)
+			if object.DeleteMarker || legacy == current {
+				continue
+			}
+			if info, err := os.Stat(legacy); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if _, err := os.Stat(current); err == nil {
+				continue
+			}
+
+			if err := os.Rename(legacy, current); err != nil {
+				return err
+			}
+			for dir := filepath.Dir(legacy); dir != bucketPath; dir = filepath.Dir(dir) {
+				if os.Remove(dir) != nil {
+					break
+				}
+			}
+		}
+	}
+	return nil
 }
 
-func isBucketEmpty(bucketPath string) (bool, error) {
-	entries, err := os.ReadDir(bucketPath)
+// RemoveOrphanedUploads deletes temporary upload files left anywhere in the
+// bucket directories, staged multipart parts and the data of deleted objects
+// included, by a crash or a dropped connection. Another process may be
+// serving the same directory, so only files not written to for longer than
+// olderThan are taken as orphaned.
+func RemoveOrphanedUploads(baseDir string, olderThan time.Duration) error {
+	entries, err := os.ReadDir(baseDir)
 	if err != nil {
-		return false, err
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
 	}
 
+	cutoff := time.Now().Add(-olderThan)
 	for _, entry := range entries {
-		if entry.Name() != "objects.csv" && !entry.IsDir() {
-			return false, nil 
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		err := filepath.WalkDir(filepath.Join(baseDir, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasPrefix(d.Name(), utils.TempUploadPrefix) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if os.IsNotExist(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if info.ModTime().After(cutoff) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
-	return true, nil
+	return nil
 }
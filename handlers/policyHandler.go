@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"triple-s/policy"
+	"triple-s/store"
+)
+
+func (b *BucketHandler) PutBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, policy.MaxSize+1))
+	if err != nil {
+		writeBodyError(w, r, err, "Failed to read request body")
+		return
+	}
+	if _, err := policy.Parse(body, bucketName); err != nil {
+		WriteXMLError(w, r, ErrMalformedPolicy.WithMessage(err.Error()))
+		return
+	}
+
+	if !b.updateBucketConfig(w, r, bucketName, func(bucket *store.Bucket) {
+		bucket.Policy = string(body)
+	}) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *BucketHandler) GetBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	bucket, err := b.Store.GetBucket(bucketName)
+	if errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+	if bucket.Policy == "" {
+		WriteXMLError(w, r, ErrNoSuchBucketPolicy)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, bucket.Policy)
+}
+
+func (b *BucketHandler) DeleteBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	if !b.updateBucketConfig(w, r, bucketName, func(bucket *store.Bucket) {
+		bucket.Policy = ""
+	}) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
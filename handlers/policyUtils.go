@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"triple-s/policy"
+	"triple-s/store"
+)
+
+// accessChecker decides what the principal of one request may do in one
+// bucket. Without a policy, and for anything the policy does not mention,
+// signed requests are allowed and anonymous ones only where an ACL grants
+// it; an explicit Deny applies to everyone.
+type accessChecker struct {
+	policy    *policy.Policy
+	request   policy.Request
+	anonymous bool
+	bucketACL string
+}
+
+func newAccessChecker(metadata store.MetadataStore, r *http.Request, bucketName string) (accessChecker, error) {
+	principal, anonymous := requestPrincipal(r)
+	checker := accessChecker{
+		request: policy.Request{
+			Principal: principal,
+			Context:   requestConditions(r),
+		},
+		anonymous: anonymous,
+	}
+
+	if bucketName == "" {
+		return checker, nil
+	}
+
+	bucket, err := metadata.GetBucket(bucketName)
+	if errors.Is(err, store.ErrBucketNotFound) {
+		return checker, nil
+	} else if err != nil {
+		return accessChecker{}, err
+	}
+
+	checker.bucketACL = bucket.ACL
+	if bucket.Policy != "" {
+		checker.policy, err = policy.Parse([]byte(bucket.Policy), bucketName)
+		if err != nil {
+			return accessChecker{}, err
+		}
+	}
+	return checker, nil
+}
+
+// allows checks action on resource; acl is the canned ACL that applies to
+// it, see policy.ACLAllowsAnonymous.
+func (c accessChecker) allows(action, resource, acl string) bool {
+	if c.policy != nil {
+		request := c.request
+		request.Action = action
+		request.Resource = resource
+		switch c.policy.Evaluate(request) {
+		case policy.Allowed:
+			return true
+		case policy.Denied:
+			return false
+		}
+	}
+	return !c.anonymous || policy.ACLAllowsAnonymous(acl, action)
+}
+
+// requestConditions collects the condition keys policies can test.
+func requestConditions(r *http.Request) map[string]string {
+	conditions := make(map[string]string)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		conditions["aws:SourceIp"] = host
+	}
+
+	query := r.URL.Query()
+	for _, name := range []string{"prefix", "delimiter"} {
+		if query.Has(name) {
+			conditions["s3:"+name] = query.Get(name)
+		}
+	}
+	return conditions
+}
+
+// authorize reports whether the request may perform action on the bucket,
+// or on version versionID of key within it, and answers 403 if not.
+func authorize(metadata store.MetadataStore, w http.ResponseWriter, r *http.Request, bucketName, key, versionID, action string) bool {
+	checker, err := newAccessChecker(metadata, r, bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket policy"))
+		return false
+	}
+
+	acl := checker.bucketACL
+	if checker.anonymous && policy.IsObjectRead(action) {
+		// Only the metadata is needed, not the path of the data. A missing
+		// object has no ACL and stays private.
+		acl = ""
+		if object, _, err := lookupVersion(metadata, "", bucketName, key, versionID); err == nil {
+			acl = object.ACL
+		}
+	}
+
+	if !checker.allows(action, policy.Resource(bucketName, key), acl) {
+		WriteXMLError(w, r, ErrAccessDenied)
+		return false
+	}
+	return true
+}
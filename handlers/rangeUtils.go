@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxRanges caps the parts of a multipart/byteranges response. A header
+// asking for more is ignored and the whole object sent instead.
+const maxRanges = 100
+
+var errRangeNotSatisfiable = errors.New("the requested range is not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange parses a Range header against an object of the given size.
+// Syntactically invalid headers are ignored, as RFC 9110 allows, and yield
+// no ranges. Overlapping and adjacent ranges are coalesced, so the parts
+// never add up to more than the object. errRangeNotSatisfiable is returned
+// when every range falls outside the object.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	satisfiable := false
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		if first == "" {
+			// Suffix range: the last N bytes of the object.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			satisfiable = true
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			end = min(end, size-1)
+		}
+
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+		satisfiable = true
+	}
+
+	if !satisfiable {
+		return nil, errRangeNotSatisfiable
+	}
+
+	ranges = coalesceRanges(ranges)
+	if len(ranges) > maxRanges {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// coalesceRanges sorts ranges by offset and merges those that overlap or
+// touch.
+func coalesceRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	merged := ranges[:1]
+	for _, br := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if br.start > last.start+last.length {
+			merged = append(merged, br)
+			continue
+		}
+		last.length = max(last.length, br.start+br.length-last.start)
+	}
+	return merged
+}
+
+// serveRanges writes a 206 Partial Content response for ranges, using a
+// multipart/byteranges body when more than one range was requested.
+func serveRanges(w http.ResponseWriter, file *os.File, size int64, contentType string, ranges []byteRange) error {
+	if len(ranges) == 1 {
+		br := ranges[0]
+		w.Header().Set("Content-Range", br.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(br.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+
+		if _, err := file.Seek(br.start, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.CopyN(w, file, br.length)
+		return err
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, br := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {br.contentRange(size)},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := file.Seek(br.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(part, file, br.length); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// rangeApplies reports whether an If-Range header, if any, still matches the
+// object so that the Range header should be honored.
+func rangeApplies(r *http.Request, etag string, lastModified string) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && ifRange == etag
+	}
+	return ifRange == lastModified
+}
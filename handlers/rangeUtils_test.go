@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		header string
+		ranges []byteRange
+		err    error
+	}{
+		{"", nil, nil},
+		{"bytes=0-9", []byteRange{{0, 10}}, nil},
+		{"bytes=90-", []byteRange{{90, 10}}, nil},
+		{"bytes=90-500", []byteRange{{90, 10}}, nil},
+		{"bytes=-10", []byteRange{{90, 10}}, nil},
+		{"bytes=-500", []byteRange{{0, 100}}, nil},
+		{"bytes=0-9,20-29", []byteRange{{0, 10}, {20, 10}}, nil},
+		{"bytes=20-29, 0-9", []byteRange{{0, 10}, {20, 10}}, nil},
+		{"bytes=0-9,5-14", []byteRange{{0, 15}}, nil},
+		{"bytes=0-9,10-19", []byteRange{{0, 20}}, nil},
+		{"bytes=0-49,10-19", []byteRange{{0, 50}}, nil},
+		{"bytes=0-,-10", []byteRange{{0, 100}}, nil},
+		{"bytes=80-89,-15", []byteRange{{80, 20}}, nil},
+		{"bytes=0-9,200-300", []byteRange{{0, 10}}, nil},
+		{"bytes=100-", nil, errRangeNotSatisfiable},
+		{"bytes=100-200,300-", nil, errRangeNotSatisfiable},
+		{"bytes=-0", nil, errRangeNotSatisfiable},
+		{"bytes=9-0", nil, nil},
+		{"bytes=a-b", nil, nil},
+		{"bytes=0-9,junk", nil, nil},
+		{"items=0-9", nil, nil},
+		{"bytes=" + strings.Repeat("0-0,", maxRanges) + "2-2,4-4", []byteRange{{0, 1}, {2, 1}, {4, 1}}, nil},
+	}
+
+	for _, tc := range tests {
+		ranges, err := parseRange(tc.header, size)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("parseRange(%q): got error %v, want %v", tc.header, err, tc.err)
+		}
+		if !reflect.DeepEqual(ranges, tc.ranges) {
+			t.Fatalf("parseRange(%q): got %v, want %v", tc.header, ranges, tc.ranges)
+		}
+	}
+
+	// More disjoint ranges than maxRanges are ignored altogether.
+	var many []string
+	for i := 0; i <= maxRanges; i++ {
+		many = append(many, fmt.Sprintf("%d-%d", 2*i, 2*i))
+	}
+	if ranges, err := parseRange("bytes="+strings.Join(many, ","), 1000); err != nil || ranges != nil {
+		t.Fatalf("%d ranges: got %v, %v; want the whole object", len(many), ranges, err)
+	}
+
+	if ranges, err := parseRange("bytes=-10", 0); !errors.Is(err, errRangeNotSatisfiable) {
+		t.Fatalf("suffix range of an empty object: got %v, %v; want %v", ranges, err, errRangeNotSatisfiable)
+	}
+}
+
+func TestGetObjectRange(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	const body = "0123456789abcdefghij"
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/key", body, "Content-Type", "text/plain")
+	expectStatus(t, w, http.StatusOK, "PUT")
+	etag := w.Header().Get("ETag")
+
+	tests := []struct {
+		name         string
+		header       []string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"no range", nil, http.StatusOK, "", body},
+		{"first bytes", []string{"Range", "bytes=0-4"}, http.StatusPartialContent, "bytes 0-4/20", "01234"},
+		{"open ended", []string{"Range", "bytes=15-"}, http.StatusPartialContent, "bytes 15-19/20", "fghij"},
+		{"suffix", []string{"Range", "bytes=-3"}, http.StatusPartialContent, "bytes 17-19/20", "hij"},
+		{"suffix longer than the object", []string{"Range", "bytes=-50"}, http.StatusPartialContent, "bytes 0-19/20", body},
+		{"overlapping ranges coalesce", []string{"Range", "bytes=0-4,2-7"}, http.StatusPartialContent, "bytes 0-7/20", "01234567"},
+		{"unsatisfiable", []string{"Range", "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */20", ""},
+		{"malformed range is ignored", []string{"Range", "bytes=5-1"}, http.StatusOK, "", body},
+		{"If-Range matches", []string{"Range", "bytes=0-4", "If-Range", etag}, http.StatusPartialContent, "bytes 0-4/20", "01234"},
+		{"If-Range is stale", []string{"Range", "bytes=0-4", "If-Range", `"other"`}, http.StatusOK, "", body},
+	}
+
+	for _, tc := range tests {
+		w := do(t, h, http.MethodGet, "/"+testBucket+"/key", "", tc.header...)
+		expectStatus(t, w, tc.status, tc.name)
+		if got := w.Header().Get("Content-Range"); got != tc.contentRange {
+			t.Fatalf("%s: got Content-Range %q, want %q", tc.name, got, tc.contentRange)
+		}
+		if tc.status == http.StatusRequestedRangeNotSatisfiable {
+			if !strings.Contains(w.Body.String(), "InvalidRange") {
+				t.Fatalf("%s: got %s, want InvalidRange", tc.name, w.Body.String())
+			}
+			continue
+		}
+		if w.Body.String() != tc.body {
+			t.Fatalf("%s: got body %q, want %q", tc.name, w.Body.String(), tc.body)
+		}
+	}
+}
+
+func TestGetObjectMultipleRanges(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	const body = "0123456789abcdefghij"
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/key", body, "Content-Type", "text/plain"), http.StatusOK, "PUT")
+
+	w := do(t, h, http.MethodGet, "/"+testBucket+"/key", "", "Range", "bytes=-2,0-1,5-6,1-2")
+	expectStatus(t, w, http.StatusPartialContent, "GET with several ranges")
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("got Content-Type %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+	}
+
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-2/20", "012"},
+		{"bytes 5-6/20", "56"},
+		{"bytes 18-19/20", "ij"},
+	}
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Fatalf("got %d parts, want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) {
+			t.Fatalf("got more than %d parts", len(want))
+		}
+		data, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Range") != want[i].contentRange || string(data) != want[i].body {
+			t.Fatalf("part %d: got %q with %q, want %q with %q", i, part.Header.Get("Content-Range"), data, want[i].contentRange, want[i].body)
+		}
+		if part.Header.Get("Content-Type") != "text/plain" {
+			t.Fatalf("part %d: got Content-Type %q, want text/plain", i, part.Header.Get("Content-Type"))
+		}
+	}
+}
@@ -14,6 +14,6 @@ func (rt *RootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete:
 		fmt.Fprintln(w, "What do do. You can only use methid get with that")
 	default:
-		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+		WriteXMLError(w, r, ErrMethodNotAllowed)
 	}
 }
@@ -0,0 +1,100 @@
+package handlers
+
+import "net/http"
+
+// route is one S3 operation: the requests it matches, the action bucket
+// policies and ACLs check for it, and the method that serves it. Routing,
+// authorization and metrics all classify requests through routes, so they
+// cannot disagree about what a request is.
+type route struct {
+	operation string
+	method    string
+
+	// query and header, when set, must be present on the request. Routes
+	// are tried in order, so the ones without them act as the fallback.
+	query  string
+	header string
+
+	// action is the policy action; versionAction replaces it when the
+	// request names a versionId. An empty action means the handler checks
+	// access itself.
+	action        string
+	versionAction string
+
+	bucket func(*BucketHandler, http.ResponseWriter, *http.Request)
+	object func(*ObjectHandler, http.ResponseWriter, *http.Request)
+}
+
+var serviceRoutes = []route{
+	{operation: "ListBuckets", method: http.MethodGet, action: "s3:ListAllMyBuckets", bucket: (*BucketHandler).ListBuckets},
+}
+
+var bucketRoutes = []route{
+	{operation: "ListMultipartUploads", method: http.MethodGet, query: "uploads", action: "s3:ListBucketMultipartUploads", bucket: (*BucketHandler).ListMultipartUploads},
+	{operation: "GetBucketVersioning", method: http.MethodGet, query: "versioning", action: "s3:GetBucketVersioning", bucket: (*BucketHandler).GetBucketVersioning},
+	{operation: "ListObjectVersions", method: http.MethodGet, query: "versions", action: "s3:ListBucketVersions", bucket: (*BucketHandler).ListObjectVersions},
+	{operation: "GetBucketPolicy", method: http.MethodGet, query: "policy", action: "s3:GetBucketPolicy", bucket: (*BucketHandler).GetBucketPolicy},
+	{operation: "GetBucketAcl", method: http.MethodGet, query: "acl", action: "s3:GetBucketAcl", bucket: (*BucketHandler).GetBucketACL},
+	{operation: "ListObjects", method: http.MethodGet, action: "s3:ListBucket", bucket: (*BucketHandler).ListObjects},
+	{operation: "HeadBucket", method: http.MethodHead, action: "s3:ListBucket", bucket: (*BucketHandler).HeadBucket},
+	{operation: "PutBucketVersioning", method: http.MethodPut, query: "versioning", action: "s3:PutBucketVersioning", bucket: (*BucketHandler).PutBucketVersioning},
+	{operation: "PutBucketPolicy", method: http.MethodPut, query: "policy", action: "s3:PutBucketPolicy", bucket: (*BucketHandler).PutBucketPolicy},
+	{operation: "PutBucketAcl", method: http.MethodPut, query: "acl", action: "s3:PutBucketAcl", bucket: (*BucketHandler).PutBucketACL},
+	{operation: "CreateBucket", method: http.MethodPut, action: "s3:CreateBucket", bucket: (*BucketHandler).CreateBucket},
+	// Every key is checked against s3:DeleteObject by the handler.
+	{operation: "DeleteObjects", method: http.MethodPost, query: "delete", bucket: (*BucketHandler).DeleteObjects},
+	{operation: "DeleteBucketPolicy", method: http.MethodDelete, query: "policy", action: "s3:DeleteBucketPolicy", bucket: (*BucketHandler).DeleteBucketPolicy},
+	{operation: "DeleteBucket", method: http.MethodDelete, action: "s3:DeleteBucket", bucket: (*BucketHandler).DeleteBucket},
+}
+
+var objectRoutes = []route{
+	{operation: "ListParts", method: http.MethodGet, query: "uploadId", action: "s3:ListMultipartUploadParts", object: (*ObjectHandler).ListParts},
+	{operation: "GetObjectAcl", method: http.MethodGet, query: "acl", action: "s3:GetObjectAcl", versionAction: "s3:GetObjectVersionAcl", object: (*ObjectHandler).GetObjectACL},
+	{operation: "GetObject", method: http.MethodGet, action: "s3:GetObject", versionAction: "s3:GetObjectVersion", object: (*ObjectHandler).GetObject},
+	{operation: "HeadObject", method: http.MethodHead, action: "s3:GetObject", versionAction: "s3:GetObjectVersion", object: (*ObjectHandler).HeadObject},
+	{operation: "PutObjectAcl", method: http.MethodPut, query: "acl", action: "s3:PutObjectAcl", object: (*ObjectHandler).PutObjectACL},
+	{operation: "UploadPart", method: http.MethodPut, query: "uploadId", action: "s3:PutObject", object: (*ObjectHandler).UploadPart},
+	// The copy source is checked against s3:GetObject by the handler.
+	{operation: "CopyObject", method: http.MethodPut, header: "X-Amz-Copy-Source", action: "s3:PutObject", object: (*ObjectHandler).CopyObject},
+	{operation: "PutObject", method: http.MethodPut, action: "s3:PutObject", object: (*ObjectHandler).UploadObject},
+	{operation: "CreateMultipartUpload", method: http.MethodPost, query: "uploads", action: "s3:PutObject", object: (*ObjectHandler).CreateMultipartUpload},
+	{operation: "CompleteMultipartUpload", method: http.MethodPost, query: "uploadId", action: "s3:PutObject", object: (*ObjectHandler).CompleteMultipartUpload},
+	{operation: "AbortMultipartUpload", method: http.MethodDelete, query: "uploadId", action: "s3:AbortMultipartUpload", object: (*ObjectHandler).AbortMultipartUpload},
+	{operation: "DeleteObject", method: http.MethodDelete, action: "s3:DeleteObject", versionAction: "s3:DeleteObjectVersion", object: (*ObjectHandler).DeleteObject},
+}
+
+// classify finds the route of a request, by whether it addresses the
+// service, a bucket or an object. ok is false when no operation matches.
+func classify(r *http.Request) (rt route, ok bool) {
+	bucketName, objectKey := parseBucketAndObject(r.URL.Path)
+	routes := objectRoutes
+	switch {
+	case bucketName == "":
+		routes = serviceRoutes
+	case objectKey == "":
+		routes = bucketRoutes
+	}
+
+	query := r.URL.Query()
+	for _, rt := range routes {
+		if rt.method != r.Method {
+			continue
+		}
+		if rt.query != "" && !query.Has(rt.query) {
+			continue
+		}
+		if rt.header != "" && r.Header.Get(rt.header) == "" {
+			continue
+		}
+		return rt, true
+	}
+	return route{}, false
+}
+
+// actionFor is the policy action the route checks for r.
+func (rt route) actionFor(r *http.Request) string {
+	if rt.versionAction != "" && r.URL.Query().Get("versionId") != "" {
+		return rt.versionAction
+	}
+	return rt.action
+}
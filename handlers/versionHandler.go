@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"triple-s/store"
+)
+
+func (b *BucketHandler) PutBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	// Clients may or may not send the S3 namespace, so the request is not
+	// decoded into the namespaced response type.
+	var config struct {
+		XMLName xml.Name `xml:"VersioningConfiguration"`
+		Status  string   `xml:"Status"`
+	}
+	if !decodeXMLBody(w, r, 1<<20, &config, ErrMalformedXML) {
+		return
+	}
+	if config.Status != store.VersioningEnabled && config.Status != store.VersioningSuspended {
+		WriteXMLError(w, r, ErrIllegalVersioningConfiguration)
+		return
+	}
+
+	if !b.updateBucketConfig(w, r, bucketName, func(bucket *store.Bucket) {
+		bucket.Versioning = config.Status
+	}) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (b *BucketHandler) GetBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	bucket, err := b.Store.GetBucket(bucketName)
+	if errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(VersioningConfiguration{Status: bucket.Versioning})
+}
+
+func (b *BucketHandler) ListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+
+	if _, err := b.Store.GetBucket(bucketName); errors.Is(err, store.ErrBucketNotFound) {
+		WriteXMLError(w, r, ErrNoSuchBucket)
+		return
+	} else if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read bucket metadata"))
+		return
+	}
+
+	query := r.URL.Query()
+	response := ListVersionsResult{
+		Name:            bucketName,
+		Prefix:          query.Get("prefix"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         maxListKeys,
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			WriteXMLError(w, r, ErrInvalidArgument.WithMessage("max-keys must be a non-negative integer"))
+			return
+		}
+		response.MaxKeys = min(n, maxListKeys)
+	}
+
+	objects, err := b.Store.ListObjects(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read object metadata"))
+		return
+	}
+	versions, err := b.Store.ListVersions(bucketName)
+	if err != nil {
+		WriteXMLError(w, r, ErrInternalError.WithMessage("Failed to read version metadata"))
+		return
+	}
+
+	// Versions are listed by key, and newest first within a key.
+	byKey := make(map[string][]store.Object)
+	for _, object := range objects {
+		byKey[object.Key] = append(byKey[object.Key], object)
+	}
+	for _, version := range versions {
+		byKey[version.Key] = append(byKey[version.Key], version)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		if strings.HasPrefix(key, response.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key < response.KeyMarker {
+			continue
+		}
+
+		// Entries of the key marker are skipped up to and including the
+		// version ID marker, or entirely when there is none.
+		skipping := key == response.KeyMarker
+		entries := byKey[key]
+		sortNewestFirst(entries)
+		for i, entry := range entries {
+			versionID := entry.VersionID
+			if versionID == "" {
+				versionID = nullVersionID
+			}
+
+			if skipping {
+				if response.VersionIDMarker != "" && versionID == response.VersionIDMarker {
+					skipping = false
+				}
+				continue
+			}
+
+			if len(response.Versions) == response.MaxKeys {
+				// With max-keys=0 the response only tells whether there is
+				// anything to list, and has no entry to continue from.
+				response.IsTruncated = true
+				if response.MaxKeys > 0 {
+					last := response.Versions[len(response.Versions)-1]
+					response.NextKeyMarker = last.Key
+					response.NextVersionIDMarker = last.VersionID
+				}
+				break
+			}
+			response.Versions = append(response.Versions, versionEntry(entry, versionID, i == 0))
+		}
+		if response.IsTruncated {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func versionEntry(object store.Object, versionID string, isLatest bool) VersionEntry {
+	entry := VersionEntry{
+		XMLName:      xml.Name{Space: s3Namespace, Local: "Version"},
+		Key:          object.Key,
+		VersionID:    versionID,
+		IsLatest:     isLatest,
+		LastModified: object.LastModified.UTC().Format(s3TimeFormat),
+	}
+	if object.DeleteMarker {
+		entry.XMLName.Local = "DeleteMarker"
+		return entry
+	}
+
+	size := object.Size
+	entry.ETag = quoteETag(object.ETag)
+	entry.Size = &size
+	entry.StorageClass = "STANDARD"
+	return entry
+}
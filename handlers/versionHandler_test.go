@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func enableVersioning(t *testing.T, h http.Handler) {
+	t.Helper()
+
+	body := `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"?versioning", body), http.StatusOK, "PutBucketVersioning")
+}
+
+func listVersions(t *testing.T, h http.Handler, query string) ListVersionsResult {
+	t.Helper()
+
+	w := do(t, h, http.MethodGet, "/"+testBucket+"?versions&"+query, "")
+	expectStatus(t, w, http.StatusOK, "ListObjectVersions")
+	var result ListVersionsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestListObjectVersionsMaxKeysZero(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	enableVersioning(t, h)
+
+	if result := listVersions(t, h, "max-keys=0"); result.IsTruncated || len(result.Versions) != 0 {
+		t.Fatalf("empty bucket: got %d versions, truncated %v; want none", len(result.Versions), result.IsTruncated)
+	}
+
+	for _, body := range []string{"v1", "v2"} {
+		expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"/key", body), http.StatusOK, "PUT")
+	}
+	result := listVersions(t, h, "max-keys=0")
+	if !result.IsTruncated || len(result.Versions) != 0 || result.NextKeyMarker != "" {
+		t.Fatalf("got %d versions, truncated %v, next key marker %q; want none, truncated", len(result.Versions), result.IsTruncated, result.NextKeyMarker)
+	}
+}
+
+func expectVersion(t *testing.T, h http.Handler, versionID, want string) {
+	t.Helper()
+	w := do(t, h, http.MethodGet, "/"+testBucket+"/key?versionId="+versionID, "")
+	expectStatus(t, w, http.StatusOK, "GET version "+versionID)
+	if w.Body.String() != want {
+		t.Fatalf("GET version %s: got %q, want %q", versionID, w.Body.String(), want)
+	}
+}
+
+func TestObjectVersions(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/key", "v0")
+	expectStatus(t, w, http.StatusOK, "PUT before versioning")
+	if id := w.Header().Get("X-Amz-Version-Id"); id != "" {
+		t.Fatalf("PUT before versioning: got version %q, want none", id)
+	}
+
+	enableVersioning(t, h)
+	var ids []string
+	for _, body := range []string{"v1", "v2"} {
+		w := do(t, h, http.MethodPut, "/"+testBucket+"/key", body)
+		expectStatus(t, w, http.StatusOK, "PUT "+body)
+		ids = append(ids, w.Header().Get("X-Amz-Version-Id"))
+	}
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Fatalf("got version IDs %q, want two distinct IDs", ids)
+	}
+
+	w = do(t, h, http.MethodGet, "/"+testBucket+"/key", "")
+	expectStatus(t, w, http.StatusOK, "GET")
+	if w.Body.String() != "v2" || w.Header().Get("X-Amz-Version-Id") != ids[1] {
+		t.Fatalf("GET: got %q version %q, want v2 version %s", w.Body.String(), w.Header().Get("X-Amz-Version-Id"), ids[1])
+	}
+	expectVersion(t, h, ids[0], "v1")
+	expectVersion(t, h, "null", "v0")
+
+	w = do(t, h, http.MethodDelete, "/"+testBucket+"/key", "")
+	expectStatus(t, w, http.StatusNoContent, "DELETE")
+	marker := w.Header().Get("X-Amz-Version-Id")
+	if w.Header().Get("X-Amz-Delete-Marker") != "true" || marker == "" {
+		t.Fatalf("DELETE: got headers %v, want a delete marker", w.Header())
+	}
+
+	w = do(t, h, http.MethodGet, "/"+testBucket+"/key", "")
+	expectStatus(t, w, http.StatusNotFound, "GET behind a delete marker")
+	if w.Header().Get("X-Amz-Delete-Marker") != "true" || w.Header().Get("X-Amz-Version-Id") != marker {
+		t.Fatalf("GET behind a delete marker: got headers %v", w.Header())
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/key?versionId="+marker, ""), http.StatusMethodNotAllowed, "GET the delete marker")
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/key?versionId=0000", ""), http.StatusNotFound, "GET an unknown version")
+	expectVersion(t, h, ids[1], "v2")
+
+	listed := listVersions(t, h, "")
+	var kinds []string
+	for _, version := range listed.Versions {
+		kinds = append(kinds, fmt.Sprintf("%s %s %v", version.XMLName.Local, version.VersionID, version.IsLatest))
+	}
+	want := []string{
+		"DeleteMarker " + marker + " true",
+		"Version " + ids[1] + " false",
+		"Version " + ids[0] + " false",
+		"Version null false",
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("got versions %q, want %q", kinds, want)
+	}
+
+	// Removing the delete marker, then the newest version, brings back the
+	// version before it.
+	expectStatus(t, do(t, h, http.MethodDelete, "/"+testBucket+"/key?versionId="+marker, ""), http.StatusNoContent, "DELETE the marker")
+	expectObject(t, h, "key", "v2")
+	expectStatus(t, do(t, h, http.MethodDelete, "/"+testBucket+"/key?versionId="+ids[1], ""), http.StatusNoContent, "DELETE v2")
+	expectObject(t, h, "key", "v1")
+	expectStatus(t, do(t, h, http.MethodGet, "/"+testBucket+"/key?versionId="+ids[1], ""), http.StatusNotFound, "GET deleted v2")
+}
+
+// A suspended bucket writes the null version in place and keeps the versions
+// written while it was enabled.
+func TestSuspendedVersioning(t *testing.T) {
+	h := newTestServer(t)
+	createBucket(t, h)
+	enableVersioning(t, h)
+	w := do(t, h, http.MethodPut, "/"+testBucket+"/key", "v1")
+	expectStatus(t, w, http.StatusOK, "PUT v1")
+	id := w.Header().Get("X-Amz-Version-Id")
+
+	body := `<VersioningConfiguration><Status>Suspended</Status></VersioningConfiguration>`
+	expectStatus(t, do(t, h, http.MethodPut, "/"+testBucket+"?versioning", body), http.StatusOK, "suspend versioning")
+	for _, body := range []string{"s1", "s2"} {
+		w := do(t, h, http.MethodPut, "/"+testBucket+"/key", body)
+		expectStatus(t, w, http.StatusOK, "PUT "+body)
+		if got := w.Header().Get("X-Amz-Version-Id"); got != "null" {
+			t.Fatalf("PUT %s: got version %q, want null", body, got)
+		}
+	}
+
+	expectVersion(t, h, "null", "s2")
+	expectVersion(t, h, id, "v1")
+	if listed := listVersions(t, h, ""); len(listed.Versions) != 2 {
+		t.Fatalf("got %d versions, want the null version and %s", len(listed.Versions), id)
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"triple-s/store"
+	"triple-s/utils"
+)
+
+const nullVersionID = "null"
+
+var (
+	errNoSuchKey      = errors.New("the specified key does not exist")
+	errNoSuchVersion  = errors.New("the specified version does not exist")
+	errIsDeleteMarker = errors.New("the specified version is a delete marker")
+)
+
+// newVersionID returns the version ID for a write into a bucket with the
+// given versioning state. Enabled buckets get a unique ID that sorts by
+// creation time, suspended buckets the "null" version, and buckets that were
+// never versioned no ID at all.
+func newVersionID(versioning string) (string, error) {
+	switch versioning {
+	case store.VersioningEnabled:
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(buf)), nil
+	case store.VersioningSuspended:
+		return nullVersionID, nil
+	default:
+		return "", nil
+	}
+}
+
+func isNullVersion(versionID string) bool {
+	return versionID == "" || versionID == nullVersionID
+}
+
+func versionMatches(stored, requested string) bool {
+	if isNullVersion(stored) {
+		return requested == nullVersionID
+	}
+	return stored == requested
+}
+
+// versionPath is where the data of a noncurrent version is kept:
+// <bucket>/.triple-s-versions/<sha256 of key>/<versionId>.
+func versionPath(bucketPath, key, versionID string) string {
+	return filepath.Join(versionDir(bucketPath, key), versionID)
+}
+
+func versionDir(bucketPath, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(bucketPath, utils.VersionsDir, hex.EncodeToString(sum[:]))
+}
+
+// pruneVersionDir removes the versions directory of key once the data of its
+// last noncurrent version has left it; one still holding versions stays.
+func pruneVersionDir(bucketPath, key string) {
+	os.Remove(versionDir(bucketPath, key))
+}
+
+// archiveCurrent turns the current version of an object into a noncurrent
+// one by moving its data into the versions directory. The objects.csv row
+// is left for the caller to replace or remove. The caller must hold the
+// object and bucket metadata locks.
+func archiveCurrent(metadata store.MetadataStore, bucketPath, bucketName string, current store.Object) (store.Object, error) {
+	version := current
+	if version.VersionID == "" {
+		version.VersionID = nullVersionID
+	}
+
+	archivedPath := versionPath(bucketPath, version.Key, version.VersionID)
+	if err := os.MkdirAll(filepath.Dir(archivedPath), os.ModePerm); err != nil {
+		return store.Object{}, err
+	}
+	if err := os.Rename(objectPath(bucketPath, current.Key), archivedPath); err != nil {
+		return store.Object{}, err
+	}
+
+	if err := metadata.PutVersion(bucketName, version); err != nil {
+		os.Rename(archivedPath, objectPath(bucketPath, current.Key))
+		return store.Object{}, err
+	}
+	return version, nil
+}
+
+// restoreArchived undoes archiveCurrent.
+func restoreArchived(metadata store.MetadataStore, bucketPath, bucketName string, previous, archived store.Object) error {
+	if err := os.Rename(versionPath(bucketPath, archived.Key, archived.VersionID), objectPath(bucketPath, previous.Key)); err != nil {
+		return err
+	}
+	pruneVersionDir(bucketPath, archived.Key)
+	if err := metadata.DeleteVersion(bucketName, archived.Key, archived.VersionID); err != nil {
+		return err
+	}
+	return metadata.PutObject(bucketName, previous)
+}
+
+// removeNullVersion permanently deletes the noncurrent "null" version of key,
+// which a write into a suspended bucket replaces.
+func removeNullVersion(metadata store.MetadataStore, bucketPath, bucketName, key string) error {
+	err := metadata.DeleteVersion(bucketName, key, nullVersionID)
+	if errors.Is(err, store.ErrVersionNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.Remove(versionPath(bucketPath, key, nullVersionID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	pruneVersionDir(bucketPath, key)
+	return nil
+}
+
+// versionsOf returns the noncurrent versions and delete markers of key,
+// newest first.
+func versionsOf(versions []store.Object, key string) []store.Object {
+	var matching []store.Object
+	for _, version := range versions {
+		if version.Key == key {
+			matching = append(matching, version)
+		}
+	}
+	sortNewestFirst(matching)
+	return matching
+}
+
+func sortNewestFirst(versions []store.Object) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+}
+
+// promoteLatestVersion makes the newest noncurrent version of key current
+// again when there is no current version and the newest one is not a delete
+// marker. It runs after a specific version was deleted.
+func promoteLatestVersion(metadata store.MetadataStore, bucketPath, bucketName, key string) error {
+	if _, err := metadata.GetObject(bucketName, key); err == nil {
+		return nil
+	} else if !errors.Is(err, store.ErrObjectNotFound) {
+		return err
+	}
+
+	versions, err := metadata.ListVersions(bucketName)
+	if err != nil {
+		return err
+	}
+
+	candidates := versionsOf(versions, key)
+	if len(candidates) == 0 || candidates[0].DeleteMarker {
+		return nil
+	}
+
+	latest := candidates[0]
+	if err := os.Rename(versionPath(bucketPath, key, latest.VersionID), objectPath(bucketPath, key)); err != nil {
+		return err
+	}
+	pruneVersionDir(bucketPath, key)
+	if err := metadata.PutObject(bucketName, latest); err != nil {
+		return err
+	}
+	return metadata.DeleteVersion(bucketName, key, latest.VersionID)
+}
+
+// lookupVersion finds the requested version of key and the path of its data.
+// An empty versionID asks for the current version. When the current version
+// is a delete marker, errNoSuchKey is returned together with the marker.
+func lookupVersion(metadata store.MetadataStore, baseDir, bucketName, key, versionID string) (store.Object, string, error) {
+	bucketPath := filepath.Join(baseDir, bucketName)
+
+	current, err := metadata.GetObject(bucketName, key)
+	if err != nil && !errors.Is(err, store.ErrObjectNotFound) {
+		return store.Object{}, "", err
+	}
+	exists := err == nil
+
+	if exists && (versionID == "" || versionMatches(current.VersionID, versionID)) {
+		return current, objectPath(bucketPath, key), nil
+	}
+
+	versions, err := metadata.ListVersions(bucketName)
+	if err != nil {
+		return store.Object{}, "", err
+	}
+	candidates := versionsOf(versions, key)
+
+	if versionID == "" {
+		if len(candidates) > 0 && candidates[0].DeleteMarker {
+			return candidates[0], "", errNoSuchKey
+		}
+		return store.Object{}, "", errNoSuchKey
+	}
+
+	for _, version := range candidates {
+		if version.VersionID == versionID {
+			if version.DeleteMarker {
+				return version, "", errIsDeleteMarker
+			}
+			return version, versionPath(bucketPath, key, versionID), nil
+		}
+	}
+	return store.Object{}, "", errNoSuchVersion
+}
+
+func setVersionHeader(w http.ResponseWriter, object store.Object) {
+	if object.VersionID != "" {
+		w.Header().Set("X-Amz-Version-Id", object.VersionID)
+	}
+}
+
+// versionLookupError maps a lookupVersion error to its S3 error and sets
+// the delete marker headers S3 clients look for on such responses.
+func versionLookupError(w http.ResponseWriter, marker store.Object, err error) APIError {
+	switch {
+	case errors.Is(err, errNoSuchKey):
+		if marker.DeleteMarker {
+			w.Header().Set("X-Amz-Delete-Marker", "true")
+			w.Header().Set("X-Amz-Version-Id", marker.VersionID)
+		}
+		return ErrNoSuchKey
+	case errors.Is(err, errNoSuchVersion):
+		return ErrNoSuchVersion
+	case errors.Is(err, errIsDeleteMarker):
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		w.Header().Set("X-Amz-Version-Id", marker.VersionID)
+		w.Header().Set("Allow", "DELETE")
+		return ErrMethodNotAllowed
+	default:
+		return ErrInternalError.WithMessage("Failed to read object metadata")
+	}
+}
@@ -1,26 +1,47 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/xml"
 	"net/http"
+	"strings"
 )
 
 type XMLErrorResponse struct {
-	XMLName xml.Name `xml:"Error"`
-	Code    int      `xml:"Code"`
-	Message string   `xml:"Message"`
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
 }
 
-func WriteXMLError(w http.ResponseWriter, statusCode int, message string) {
+// WriteXMLError writes an S3 error document for the request. The request ID
+// is the one already sent in x-amz-request-id, or a new one.
+func WriteXMLError(w http.ResponseWriter, r *http.Request, apiErr APIError) {
+	requestID := w.Header().Get("X-Amz-Request-Id")
+	if requestID == "" {
+		requestID = newRequestID()
+		w.Header().Set("X-Amz-Request-Id", requestID)
+	}
+
 	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(statusCode)
+	w.WriteHeader(apiErr.StatusCode)
 
 	errResponse := XMLErrorResponse{
-		Code:    statusCode,
-		Message: message,
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Resource:  r.URL.Path,
+		RequestID: requestID,
 	}
 
 	if err := xml.NewEncoder(w).Encode(errResponse); err != nil {
 		http.Error(w, "Failed to encode XML error response", http.StatusInternalServerError)
 	}
 }
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return strings.ToUpper(hex.EncodeToString(id))
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package locks
+
+import (
+	"os"
+)
+
+// File locks are not available here, so only the in-process locks apply.
+func lockFile(file *os.File, shared bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package locks
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Manager hands out named locks. Every lock is held in-process with a
+// sync.RWMutex; locks that are backed by a file are additionally held with
+// an advisory file lock so that several triple-s processes sharing the same
+// data directory stay consistent.
+//
+// Locks must be taken in this order to avoid deadlocks:
+// bucket -> upload -> object -> bucket metadata -> global metadata -> journal.
+type Manager struct {
+	dir   string
+	mu    sync.Mutex
+	locks map[string]*entry
+}
+
+type entry struct {
+	rw   sync.RWMutex
+	refs int
+}
+
+// NewManager returns a Manager that keeps its lock files in dir. An empty
+// dir disables cross-process file locks.
+func NewManager(dir string) (*Manager, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	return &Manager{dir: dir, locks: make(map[string]*entry)}, nil
+}
+
+// LockMetadata guards buckets.csv.
+func (m *Manager) LockMetadata() (func(), error) {
+	return m.acquire("metadata", "_buckets.lock", false)
+}
+
+// LockBucket is held exclusively while a bucket is created or deleted.
+func (m *Manager) LockBucket(bucket string) (func(), error) {
+	return m.acquire("bucket/"+bucket, bucket+".lock", false)
+}
+
+// RLockBucket is held while objects inside the bucket are changed, so the
+// bucket cannot disappear underneath them.
+func (m *Manager) RLockBucket(bucket string) (func(), error) {
+	return m.acquire("bucket/"+bucket, bucket+".lock", true)
+}
+
+// LockBucketMetadata guards the bucket's objects.csv.
+func (m *Manager) LockBucketMetadata(bucket string) (func(), error) {
+	return m.acquire("objects/"+bucket, bucket+"_objects.lock", false)
+}
+
+// LockObject serializes writers of a single key. It is only held in-process.
+func (m *Manager) LockObject(bucket, key string) (func(), error) {
+	return m.acquire("object/"+bucket+"/"+key, "", false)
+}
+
+// LockUpload serializes changes to a single multipart upload. It is only
+// held in-process.
+func (m *Manager) LockUpload(bucket, uploadID string) (func(), error) {
+	return m.acquire("upload/"+bucket+"/"+uploadID, "", false)
+}
+
+func (m *Manager) acquire(key, fileName string, shared bool) (func(), error) {
+	m.mu.Lock()
+	e, ok := m.locks[key]
+	if !ok {
+		e = &entry{}
+		m.locks[key] = e
+	}
+	e.refs++
+	m.mu.Unlock()
+
+	if shared {
+		e.rw.RLock()
+	} else {
+		e.rw.Lock()
+	}
+
+	release := func() {
+		if shared {
+			e.rw.RUnlock()
+		} else {
+			e.rw.Unlock()
+		}
+
+		m.mu.Lock()
+		e.refs--
+		if e.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+	}
+
+	if m.dir == "" || fileName == "" {
+		return release, nil
+	}
+
+	file, err := os.OpenFile(filepath.Join(m.dir, fileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if err := lockFile(file, shared); err != nil {
+		file.Close()
+		release()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(file)
+		file.Close()
+		release()
+	}, nil
+}
+
+// LockJournal is held exclusively while the metadata journal is replayed and
+// truncated.
+func (m *Manager) LockJournal() (func(), error) {
+	return m.acquire("journal", "_journal.lock", false)
+}
+
+// RLockJournal is held while a single mutation is appended to the journal and
+// applied to the CSV files.
+func (m *Manager) RLockJournal() (func(), error) {
+	return m.acquire("journal", "_journal.lock", true)
+}
@@ -1,20 +1,39 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"time"
+	"triple-s/auth"
+	"triple-s/certs"
 	"triple-s/flag"
 	"triple-s/handlers"
+	"triple-s/locks"
+	"triple-s/logging"
+	"triple-s/store"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		if err := presign(os.Args[2:]); err != nil {
+			log.Fatalf("Presign error: %v\n", err)
+		}
+		return
+	}
+
 	if err := flag.MyFlags(); err != nil {
 		log.Fatalf("Flag error: %v\n", err)
 	}
+	if *flag.PrintConfig {
+		flag.WriteConfig(os.Stdout)
+		return
+	}
 
-	port := *flag.Address
 	baseDir := *flag.Dir
 
 	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
@@ -23,17 +42,154 @@ func main() {
 		}
 	}
 
+	if err := handlers.RemoveOrphanedUploads(baseDir, orphanedUploadAge()); err != nil {
+		log.Fatalf("Failed to clean up partial uploads: %v\n", err)
+	}
+
+	lockManager, err := locks.NewManager(filepath.Join(baseDir, ".locks"))
+	if err != nil {
+		log.Fatalf("Failed to create lock directory: %v\n", err)
+	}
+
+	metadata, err := store.OpenCSVStore(baseDir, lockManager)
+	if err != nil {
+		log.Fatalf("Failed to open metadata store: %v\n", err)
+	}
+	if err := handlers.MigrateObjectFiles(baseDir, metadata); err != nil {
+		log.Fatalf("Failed to migrate object files: %v\n", err)
+	}
+
+	bucketHandler := &handlers.BucketHandler{BaseDir: baseDir, Store: metadata, Locks: lockManager}
+	objectHandler := &handlers.ObjectHandler{BaseDir: baseDir, Store: metadata, Locks: lockManager}
+
 	mux := http.NewServeMux()
 
-	mux.Handle("/", &handlers.BucketHandler{BaseDir: baseDir})
-	mux.Handle("/{bucket}", &handlers.BucketHandler{BaseDir: baseDir})
-	mux.Handle("/{bucket}/", &handlers.BucketHandler{BaseDir: baseDir})
-	mux.Handle("/{bucket}/{object}", &handlers.ObjectHandler{BaseDir: baseDir})
-	mux.Handle("/{bucket}/{object}/", &handlers.ObjectHandler{BaseDir: baseDir})
+	mux.Handle("/", bucketHandler)
+	mux.Handle("/{bucket}", bucketHandler)
+	mux.Handle("/{bucket}/", bucketHandler)
+	mux.Handle("/{bucket}/{object}", objectHandler)
+	mux.Handle("/{bucket}/{object}/", objectHandler)
+
+	var handler http.Handler = mux
+	if *flag.Credentials != "" {
+		credentials, err := auth.LoadCredentials(*flag.Credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v\n", err)
+		}
+		handler = &handlers.AuthHandler{Credentials: credentials, Next: mux}
+	}
+
+	var serverMetrics *handlers.Metrics
+	if *flag.MetricsListen != "" {
+		serverMetrics = handlers.NewMetrics()
+		handler = &handlers.MetricsHandler{Metrics: serverMetrics, Next: handler}
+	}
+
+	accessLog, err := logging.OpenRotatingFile(filepath.Join(baseDir, ".logs", "access.log"), int64(*flag.AccessLogSize)<<20, *flag.AccessLogBackups)
+	if err != nil {
+		log.Fatalf("Failed to open access log: %v\n", err)
+	}
+	handler = &handlers.LogHandler{Logger: slog.New(slog.NewJSONHandler(accessLog, nil)), Next: handler}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *flag.ReadTimeout,
+		WriteTimeout:      *flag.WriteTimeout,
+		IdleTimeout:       *flag.IdleTimeout,
+	}
+	if serverMetrics != nil {
+		server.ConnState = serverMetrics.ConnState
+	}
+
+	var certificate *certs.Reloader
+	if *flag.TLSCert != "" {
+		certificate, server.TLSConfig, err = tlsConfig()
+		if err != nil {
+			log.Fatalf("TLS error: %v\n", err)
+		}
+	}
 
-	fmt.Printf("Starting server on port %s\n", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
+	listeners, err := listen(flag.Listen)
+	if err != nil {
 		log.Fatalf("Server failed to start: %v\n", err)
 	}
+	for _, l := range listeners {
+		fmt.Printf("Starting server on %s\n", listenerName(l))
+	}
+
+	var metricsServer *http.Server
+	if serverMetrics != nil {
+		metricsServer, err = serveMetrics(*flag.MetricsListen, &handlers.MetricsEndpoint{Metrics: serverMetrics, Store: metadata})
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			log.Fatalf("Metrics server failed to start: %v\n", err)
+		}
+	}
+
+	serveErr := serve(server, listeners, *flag.ShutdownTimeout, certificate)
+	if metricsServer != nil {
+		closeMetrics(metricsServer, *flag.ShutdownTimeout)
+	}
+
+	// Nothing is being served any more: fold the journal into the CSV files
+	// and remove what interrupted uploads left behind.
+	if err := metadata.Close(); err != nil {
+		log.Printf("Failed to flush metadata: %v\n", err)
+	}
+	if err := handlers.RemoveOrphanedUploads(baseDir, orphanedUploadAge()); err != nil {
+		log.Printf("Failed to clean up partial uploads: %v\n", err)
+	}
+	accessLog.Close()
+
+	if serveErr != nil {
+		log.Fatalf("Server failed: %v\n", serveErr)
+	}
+	fmt.Println("Server stopped")
+}
+
+// orphanedUploadAge is how long a temporary upload file must have gone
+// unwritten before it counts as left behind. A request that takes longer
+// than -read-timeout to arrive is cut off, so beyond that, plus time for the
+// rename after it, no live upload can own the file.
+func orphanedUploadAge() time.Duration {
+	if *flag.ReadTimeout == 0 {
+		return 24 * time.Hour
+	}
+	return *flag.ReadTimeout + time.Hour
 }
 
+// tlsConfig sets up HTTPS from the TLS flags, generating a self-signed
+// certificate first when asked to.
+func tlsConfig() (*certs.Reloader, *tls.Config, error) {
+	if *flag.TLSSelfSigned {
+		created, err := certs.EnsureSelfSigned(*flag.TLSCert, *flag.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating a self-signed certificate: %v", err)
+		}
+		if created {
+			fmt.Printf("Generated a self-signed certificate in %s\n", *flag.TLSCert)
+		}
+	}
+
+	certificate, err := certs.NewReloader(*flag.TLSCert, *flag.TLSKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certificate.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if *flag.TLSClientCA != "" {
+		config.ClientCAs, err = certs.LoadClientCAs(*flag.TLSClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading client CAs: %v", err)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return certificate, config, nil
+}
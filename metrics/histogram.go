@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// DurationBuckets are upper bounds in seconds suited to request latencies,
+// from small metadata requests up to large uploads.
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Histogram counts observations into buckets with the given upper bounds and
+// keeps their sum and count.
+type Histogram struct {
+	f       *family
+	buckets []float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{f: newFamily(name, help, "histogram", labels), buckets: buckets}
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *Histogram) Write(w io.Writer) error {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	if err := h.f.writeHeader(w); err != nil {
+		return err
+	}
+	names := append(append([]string(nil), h.f.labels...), "le")
+	for _, s := range h.f.sorted() {
+		// Buckets are cumulative: each one also counts the ones below it.
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			if err := h.writeBucket(w, names, s.labelValues, formatValue(bound), cumulative); err != nil {
+				return err
+			}
+		}
+		if err := h.writeBucket(w, names, s.labelValues, formatValue(math.Inf(1)), s.count); err != nil {
+			return err
+		}
+
+		labels := labelString(h.f.labels, s.labelValues)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.f.name, labels, formatValue(s.value), h.f.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Histogram) writeBucket(w io.Writer, names, labelValues []string, le string, count uint64) error {
+	values := append(append([]string(nil), labelValues...), le)
+	_, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, labelString(names, values), count)
+	return err
+}
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a family of series that can write itself in the Prometheus text
+// exposition format.
+type Metric interface {
+	Write(w io.Writer) error
+}
+
+// family holds the series of one metric, keyed by their label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+
+	// Histograms only.
+	counts []uint64
+	count  uint64
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series for labelValues, creating it. The caller holds mu.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values, so that every scrape
+// lists them the same way. The caller holds mu.
+func (f *family) sorted() []*series {
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].labelValues, all[j].labelValues
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	return all
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+	return err
+}
+
+func (f *family) writeValues(w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writeHeader(w); err != nil {
+		return err
+	}
+	for _, s := range f.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.labelValues), formatValue(s.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter is a value that only goes up, such as the number of requests.
+type Counter struct {
+	f *family
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: newFamily(name, help, "counter", labels)}
+}
+
+// Add increases the series for labelValues by delta, which must not be
+// negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.f.mu.Lock()
+	c.f.get(labelValues).value += delta
+	c.f.mu.Unlock()
+}
+
+func (c *Counter) Write(w io.Writer) error {
+	return c.f.writeValues(w)
+}
+
+// Gauge is a value that goes up and down, such as open connections.
+type Gauge struct {
+	f *family
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: newFamily(name, help, "gauge", labels)}
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.get(labelValues).value += delta
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.get(labelValues).value = value
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Write(w io.Writer) error {
+	return g.f.writeValues(w)
+}
+
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func expectOutput(t *testing.T, m Metric, want string) {
+	t.Helper()
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterFormat(t *testing.T) {
+	c := NewCounter("triples_requests_total", "Requests served.\nBy method and \\ status.", "method", "code")
+	expectOutput(t, c, `# HELP triples_requests_total Requests served.\nBy method and \\ status.
+# TYPE triples_requests_total counter
+`)
+
+	c.Add(1, "PUT", "200")
+	c.Add(2, "GET", "200")
+	c.Add(0.5, "GET", "200")
+	c.Add(1, "GET", "404")
+	c.Add(1, "GET", "a \"quoted\"\\odd\nvalue")
+	expectOutput(t, c, `# HELP triples_requests_total Requests served.\nBy method and \\ status.
+# TYPE triples_requests_total counter
+triples_requests_total{method="GET",code="200"} 2.5
+triples_requests_total{method="GET",code="404"} 1
+triples_requests_total{method="GET",code="a \"quoted\"\\odd\nvalue"} 1
+triples_requests_total{method="PUT",code="200"} 1
+`)
+}
+
+func TestGaugeFormat(t *testing.T) {
+	g := NewGauge("triples_open_connections", "Open connections.")
+	g.Add(3)
+	g.Add(-5)
+	expectOutput(t, g, `# HELP triples_open_connections Open connections.
+# TYPE triples_open_connections gauge
+triples_open_connections -2
+`)
+
+	for _, tc := range []struct {
+		value float64
+		want  string
+	}{
+		{1e21, "1e+21"},
+		{0.000001, "1e-06"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	} {
+		g.Set(tc.value)
+		expectOutput(t, g, `# HELP triples_open_connections Open connections.
+# TYPE triples_open_connections gauge
+triples_open_connections `+tc.want+"\n")
+	}
+}
+
+func TestHistogramFormat(t *testing.T) {
+	h := NewHistogram("triples_request_duration_seconds", "Request latency.", []float64{1, 0.1, 0.5}, "method")
+	for _, value := range []float64{0.05, 0.1, 0.3, 2} {
+		h.Observe(value, "GET")
+	}
+	h.Observe(0.7, "DELETE")
+
+	// Buckets are sorted and cumulative, an observation equal to a bound
+	// counts in that bucket, and +Inf counts everything.
+	expectOutput(t, h, `# HELP triples_request_duration_seconds Request latency.
+# TYPE triples_request_duration_seconds histogram
+triples_request_duration_seconds_bucket{method="DELETE",le="0.1"} 0
+triples_request_duration_seconds_bucket{method="DELETE",le="0.5"} 0
+triples_request_duration_seconds_bucket{method="DELETE",le="1"} 1
+triples_request_duration_seconds_bucket{method="DELETE",le="+Inf"} 1
+triples_request_duration_seconds_sum{method="DELETE"} 0.7
+triples_request_duration_seconds_count{method="DELETE"} 1
+triples_request_duration_seconds_bucket{method="GET",le="0.1"} 2
+triples_request_duration_seconds_bucket{method="GET",le="0.5"} 3
+triples_request_duration_seconds_bucket{method="GET",le="1"} 3
+triples_request_duration_seconds_bucket{method="GET",le="+Inf"} 4
+triples_request_duration_seconds_sum{method="GET"} 2.45
+triples_request_duration_seconds_count{method="GET"} 4
+`)
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Add with a missing label value did not panic")
+		}
+	}()
+	NewCounter("triples_requests_total", "Requests served.", "method", "code").Add(1, "GET")
+}
@@ -0,0 +1,67 @@
+package policy
+
+// Canned ACLs, as set with the x-amz-acl header. Every signed request acts
+// as the owner of all buckets, so the only grants that change anything are
+// the ones to anonymous requests.
+const (
+	ACLPrivate           = "private"
+	ACLPublicRead        = "public-read"
+	ACLPublicReadWrite   = "public-read-write"
+	ACLAuthenticatedRead = "authenticated-read"
+)
+
+// ValidACL reports whether acl is a supported canned ACL.
+func ValidACL(acl string) bool {
+	switch acl {
+	case ACLPrivate, ACLPublicRead, ACLPublicReadWrite, ACLAuthenticatedRead:
+		return true
+	default:
+		return false
+	}
+}
+
+// Permissions granted to everyone by the canned ACLs. Reading a bucket lists
+// it and writing a bucket creates and deletes its objects; reading an object
+// fetches it. Object writes are governed by the bucket ACL alone.
+var (
+	bucketReadActions = []string{
+		"s3:ListBucket",
+		"s3:ListBucketVersions",
+		"s3:ListBucketMultipartUploads",
+	}
+	bucketWriteActions = []string{
+		"s3:PutObject",
+		"s3:DeleteObject",
+		"s3:DeleteObjectVersion",
+		"s3:AbortMultipartUpload",
+		"s3:ListMultipartUploadParts",
+	}
+	objectReadActions = []string{
+		"s3:GetObject",
+		"s3:GetObjectVersion",
+	}
+)
+
+// IsObjectRead reports whether action is governed by the ACL of the object
+// it reads rather than by the ACL of its bucket.
+func IsObjectRead(action string) bool {
+	return contains(objectReadActions, action)
+}
+
+// ACLAllowsAnonymous reports whether acl lets anonymous requests perform
+// action. acl is the object's ACL for object reads and the bucket's ACL for
+// everything else; an empty acl is private.
+func ACLAllowsAnonymous(acl, action string) bool {
+	if IsObjectRead(action) {
+		return acl == ACLPublicRead || acl == ACLPublicReadWrite
+	}
+
+	switch acl {
+	case ACLPublicRead:
+		return contains(bucketReadActions, action)
+	case ACLPublicReadWrite:
+		return contains(bucketReadActions, action) || contains(bucketWriteActions, action)
+	default:
+		return false
+	}
+}
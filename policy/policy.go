@@ -0,0 +1,359 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// MaxSize is the largest policy document a bucket accepts.
+const MaxSize = 20 << 10
+
+const resourcePrefix = "arn:aws:s3:::"
+
+type Effect string
+
+const (
+	Allow Effect = "Allow"
+	Deny  Effect = "Deny"
+)
+
+// Decision is the outcome of evaluating a policy against a request.
+type Decision int
+
+const (
+	// NoMatch means no statement applies; the caller falls back to its
+	// default for the principal.
+	NoMatch Decision = iota
+	Allowed
+	Denied
+)
+
+// Policy is a bucket policy in the AWS access policy language. Only the
+// parts that make sense for triple-s are understood: principals are access
+// key IDs, actions are s3:* actions and resources are S3 ARNs.
+type Policy struct {
+	Version    string      `json:"Version,omitempty"`
+	ID         string      `json:"Id,omitempty"`
+	Statements []Statement `json:"Statement"`
+}
+
+type Statement struct {
+	Sid       string                           `json:"Sid,omitempty"`
+	Effect    Effect                           `json:"Effect"`
+	Principal Principal                        `json:"Principal"`
+	Action    StringList                       `json:"Action"`
+	Resource  StringList                       `json:"Resource"`
+	Condition map[string]map[string]StringList `json:"Condition,omitempty"`
+}
+
+// Request is what a policy is evaluated against. An empty Principal is an
+// anonymous request. Context holds condition keys such as aws:SourceIp.
+type Request struct {
+	Principal string
+	Action    string
+	Resource  string
+	Context   map[string]string
+}
+
+// Resource returns the ARN of a bucket, or of an object when key is set.
+func Resource(bucket, key string) string {
+	if key == "" {
+		return resourcePrefix + bucket
+	}
+	return resourcePrefix + bucket + "/" + key
+}
+
+// Parse decodes a policy document and checks that it only refers to bucket.
+func Parse(data []byte, bucket string) (*Policy, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("policies must not exceed %d bytes", MaxSize)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("policy is not valid JSON: %v", err)
+	}
+	if err := policy.validate(bucket); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	// Statement may be a single object instead of a list.
+	var raw struct {
+		Version   string          `json:"Version"`
+		ID        string          `json:"Id"`
+		Statement json.RawMessage `json:"Statement"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	p.Version, p.ID = raw.Version, raw.ID
+	if len(raw.Statement) > 0 && raw.Statement[0] == '{' {
+		var statement Statement
+		if err := json.Unmarshal(raw.Statement, &statement); err != nil {
+			return err
+		}
+		p.Statements = []Statement{statement}
+		return nil
+	}
+	return json.Unmarshal(raw.Statement, &p.Statements)
+}
+
+func (p *Policy) validate(bucket string) error {
+	if p.Version != "" && p.Version != "2012-10-17" && p.Version != "2008-10-17" {
+		return fmt.Errorf("unsupported policy version %q", p.Version)
+	}
+	if len(p.Statements) == 0 {
+		return errors.New("policy has no statements")
+	}
+
+	for i, statement := range p.Statements {
+		if err := statement.validate(bucket); err != nil {
+			return fmt.Errorf("statement %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *Statement) validate(bucket string) error {
+	if s.Effect != Allow && s.Effect != Deny {
+		return fmt.Errorf("effect must be Allow or Deny, not %q", s.Effect)
+	}
+	if len(s.Principal) == 0 {
+		return errors.New("missing principal")
+	}
+
+	if len(s.Action) == 0 {
+		return errors.New("missing action")
+	}
+	for _, action := range s.Action {
+		if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+			return fmt.Errorf("unsupported action %q", action)
+		}
+	}
+
+	if len(s.Resource) == 0 {
+		return errors.New("missing resource")
+	}
+	for _, resource := range s.Resource {
+		rest, ok := strings.CutPrefix(resource, resourcePrefix)
+		resourceBucket, _, _ := strings.Cut(rest, "/")
+		if !ok || (resourceBucket != bucket && !strings.ContainsAny(resourceBucket, "*?")) {
+			return fmt.Errorf("resource %q is not within bucket %q", resource, bucket)
+		}
+	}
+
+	for operator, conditions := range s.Condition {
+		if _, ok := conditionOperators[operator]; !ok {
+			return fmt.Errorf("unsupported condition operator %q", operator)
+		}
+		if strings.HasSuffix(operator, "IpAddress") {
+			for _, values := range conditions {
+				for _, value := range values {
+					if _, err := parseIPRange(value); err != nil {
+						return fmt.Errorf("invalid IP range %q", value)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate applies the policy to a request. An explicit Deny always wins
+// over an Allow.
+func (p *Policy) Evaluate(request Request) Decision {
+	decision := NoMatch
+	for _, statement := range p.Statements {
+		if !statement.matches(request) {
+			continue
+		}
+		if statement.Effect == Deny {
+			return Denied
+		}
+		decision = Allowed
+	}
+	return decision
+}
+
+func (s *Statement) matches(request Request) bool {
+	if !s.Principal.matches(request.Principal) {
+		return false
+	}
+	if !s.Action.matchesAny(request.Action, true) {
+		return false
+	}
+	if !s.Resource.matchesAny(request.Resource, false) {
+		return false
+	}
+
+	for operator, conditions := range s.Condition {
+		for key, values := range conditions {
+			actual, ok := request.Context[key]
+			if !conditionOperators[operator](values, actual, ok) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// StringList is a JSON string or list of strings.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected a string or a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+func (l StringList) matchesAny(value string, ignoreCase bool) bool {
+	for _, pattern := range l {
+		if ignoreCase {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		if wildcardMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is either "*" or {"AWS": <access key IDs>}. An ARN whose last
+// path element is an access key ID, such as arn:aws:iam::000000000000:user/KEY,
+// names that key too.
+type Principal StringList
+
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var everyone string
+	if err := json.Unmarshal(data, &everyone); err == nil {
+		if everyone != "*" {
+			return fmt.Errorf("principal must be \"*\" or {\"AWS\": ...}, not %q", everyone)
+		}
+		*p = Principal{"*"}
+		return nil
+	}
+
+	var principals struct {
+		AWS StringList `json:"AWS"`
+	}
+	if err := json.Unmarshal(data, &principals); err != nil {
+		return errors.New(`principal must be "*" or {"AWS": ...}`)
+	}
+	*p = Principal(principals.AWS)
+	return nil
+}
+
+func (p Principal) matches(accessKey string) bool {
+	for _, principal := range p {
+		if principal == "*" {
+			return true
+		}
+		if accessKey == "" {
+			continue
+		}
+		if principal == accessKey || strings.HasSuffix(principal, "/"+accessKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionOperators report whether a condition holds given its values and
+// the request's value for the key, if the request has one.
+var conditionOperators = map[string]func(values StringList, actual string, present bool) bool{
+	"StringEquals": func(values StringList, actual string, present bool) bool {
+		return present && contains(values, actual)
+	},
+	"StringNotEquals": func(values StringList, actual string, present bool) bool {
+		return !present || !contains(values, actual)
+	},
+	"StringLike": func(values StringList, actual string, present bool) bool {
+		return present && values.matchesAny(actual, false)
+	},
+	"StringNotLike": func(values StringList, actual string, present bool) bool {
+		return !present || !values.matchesAny(actual, false)
+	},
+	"IpAddress": func(values StringList, actual string, present bool) bool {
+		return present && inIPRanges(values, actual)
+	},
+	"NotIpAddress": func(values StringList, actual string, present bool) bool {
+		return !present || !inIPRanges(values, actual)
+	},
+}
+
+func contains(values StringList, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func inIPRanges(ranges StringList, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, value := range ranges {
+		if prefix, err := parseIPRange(value); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPRange accepts a CIDR block or a single address.
+func parseIPRange(value string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// wildcardMatch matches value against a pattern in which * stands for any
+// run of characters and ? for a single character.
+func wildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
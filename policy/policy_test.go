@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+const testBucket = "photos"
+
+func mustParse(t *testing.T, document string) *Policy {
+	t.Helper()
+	policy, err := Parse([]byte(document), testBucket)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return policy
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		request  Request
+		decision Decision
+	}{
+		{
+			name: "Deny wins over Allow",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::photos/*"},
+				{"Effect": "Deny", "Principal": "*", "Action": "s3:DeleteObject", "Resource": "arn:aws:s3:::photos/*"},
+				{"Effect": "Allow", "Principal": "*", "Action": "s3:DeleteObject", "Resource": "arn:aws:s3:::photos/*"}
+			]}`,
+			request:  Request{Action: "s3:DeleteObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Denied,
+		},
+		{
+			name: "Deny for other actions leaves Allow",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::photos/*"},
+				{"Effect": "Deny", "Principal": "*", "Action": "s3:DeleteObject", "Resource": "arn:aws:s3:::photos/*"}
+			]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "no statement applies",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Action: "s3:PutObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: NoMatch,
+		},
+		{
+			name:     "single statement object",
+			policy:   `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "actions ignore case",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "S3:getobject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "action wildcard",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:Get*", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Action: "s3:GetObjectVersion", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "? matches one character",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/cat?.jpg"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat1.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "? does not match two characters",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/cat?.jpg"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat12.jpg")},
+			decision: NoMatch,
+		},
+		{
+			name:     "? does not match nothing",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/cat?.jpg"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: NoMatch,
+		},
+		{
+			name:     "* spans slashes",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/public/*.jpg"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "public/2024/cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "* needs the rest of the pattern",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/public/*.jpg"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "public/cat.png")},
+			decision: NoMatch,
+		},
+		{
+			name:     "object pattern does not cover the bucket",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Action: "s3:ListBucket", Resource: Resource(testBucket, "")},
+			decision: NoMatch,
+		},
+		{
+			name:     "named principal",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": ["AKIDOTHER", "AKIDTEST"]}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Principal: "AKIDTEST", Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "principal as a user ARN",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::000000000000:user/AKIDTEST"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Principal: "AKIDTEST", Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Allowed,
+		},
+		{
+			name:     "named principal excludes anonymous",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "AKIDTEST"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: NoMatch,
+		},
+		{
+			name:     "IpAddress in range",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/8"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:SourceIp": "10.1.2.3"}},
+			decision: Allowed,
+		},
+		{
+			name:     "IpAddress with an IPv4-mapped source",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/8"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:SourceIp": "::ffff:10.1.2.3"}},
+			decision: Allowed,
+		},
+		{
+			name:     "IpAddress with an IPv4-mapped range address",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"IpAddress": {"aws:SourceIp": "::ffff:10.1.2.3"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:SourceIp": "10.1.2.3"}},
+			decision: Allowed,
+		},
+		{
+			name:     "IpAddress out of range",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/8"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:SourceIp": "::ffff:192.168.1.1"}},
+			decision: NoMatch,
+		},
+		{
+			name:     "NotIpAddress with an IPv4-mapped source in range",
+			policy:   `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::photos/*", "Condition": {"NotIpAddress": {"aws:SourceIp": ["10.0.0.0/8", "2001:db8::/32"]}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:SourceIp": "::ffff:10.1.2.3"}},
+			decision: NoMatch,
+		},
+		{
+			name:     "NotIpAddress outside every range",
+			policy:   `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::photos/*", "Condition": {"NotIpAddress": {"aws:SourceIp": ["10.0.0.0/8", "2001:db8::/32"]}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:SourceIp": "192.168.1.1"}},
+			decision: Denied,
+		},
+		{
+			name:     "StringEquals with the key missing",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"StringEquals": {"aws:Referer": "https://example.com"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: NoMatch,
+		},
+		{
+			name:     "StringNotEquals with the key missing",
+			policy:   `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"StringNotEquals": {"aws:Referer": "https://example.com"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg")},
+			decision: Denied,
+		},
+		{
+			name:     "StringNotEquals with a listed value",
+			policy:   `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"StringNotEquals": {"aws:Referer": "https://example.com"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:Referer": "https://example.com"}},
+			decision: NoMatch,
+		},
+		{
+			name:     "StringLike",
+			policy:   `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"StringLike": {"aws:Referer": "https://*.example.com/*"}}}]}`,
+			request:  Request{Action: "s3:GetObject", Resource: Resource(testBucket, "cat.jpg"), Context: map[string]string{"aws:Referer": "https://www.example.com/gallery"}},
+			decision: Allowed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := mustParse(t, tc.policy).Evaluate(tc.request); got != tc.decision {
+				t.Fatalf("got decision %d, want %d", got, tc.decision)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		reason string
+	}{
+		{"resource in another bucket", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::videos/*"}]}`, "not within bucket"},
+		{"bucket name prefix", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos-private/*"}]}`, "not within bucket"},
+		{"one of several resources elsewhere", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": ["arn:aws:s3:::photos/*", "arn:aws:s3:::videos/*"]}]}`, "not within bucket"},
+		{"resource that is not an S3 ARN", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "photos/*"}]}`, "not within bucket"},
+		{"unknown effect", `{"Statement": [{"Effect": "Maybe", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`, "effect"},
+		{"principal that is not *", `{"Statement": [{"Effect": "Allow", "Principal": "AKIDTEST", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`, "principal"},
+		{"non-S3 action", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "iam:CreateUser", "Resource": "arn:aws:s3:::photos/*"}]}`, "unsupported action"},
+		{"unknown condition operator", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"DateGreaterThan": {"aws:CurrentTime": "2020-01-01T00:00:00Z"}}}]}`, "condition operator"},
+		{"bad IP range", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*", "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/33"}}}]}`, "IP range"},
+		{"no statements", `{"Version": "2012-10-17", "Statement": []}`, "no statements"},
+		{"unknown version", `{"Version": "2020-01-01", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}}`, "version"},
+		{"too large", `{"Id": "` + strings.Repeat("x", MaxSize) + `"}`, "exceed"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse([]byte(tc.policy), testBucket)
+			if err == nil {
+				t.Fatal("Parse accepted the policy")
+			}
+			if !strings.Contains(err.Error(), tc.reason) {
+				t.Fatalf("got error %q, want one about %q", err, tc.reason)
+			}
+		})
+	}
+}
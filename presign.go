@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"triple-s/auth"
+	"triple-s/flag"
+)
+
+// presign implements "triple-s presign": it prints a presigned URL signed
+// with a key from the credentials file.
+func presign(args []string) error {
+	options, err := flag.PresignFlags(args)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := auth.LoadCredentials(options.Credentials)
+	if err != nil {
+		return err
+	}
+
+	accessKey := options.AccessKey
+	if accessKey == "" {
+		if len(credentials) != 1 {
+			keys := make([]string, 0, len(credentials))
+			for key := range credentials {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("the credentials file holds several keys, pick one with -access-key: %s", strings.Join(keys, ", "))
+		}
+		for key := range credentials {
+			accessKey = key
+		}
+	}
+
+	secretKey, ok := credentials[accessKey]
+	if !ok {
+		return fmt.Errorf("access key %q is not in %s", accessKey, options.Credentials)
+	}
+
+	url, err := auth.Presign(auth.PresignRequest{
+		Method:    options.Method,
+		Endpoint:  options.Endpoint,
+		Bucket:    options.Bucket,
+		Key:       options.Key,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Region:    options.Region,
+		Expires:   options.Expires,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(url)
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"triple-s/certs"
+	"triple-s/flag"
+)
+
+// inFlight counts the requests being served, so that shutdown can wait for
+// handlers cut off by Server.Close to return before the metadata is closed.
+type inFlight struct {
+	wg   sync.WaitGroup
+	next http.Handler
+}
+
+func (f *inFlight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.wg.Add(1)
+	defer f.wg.Done()
+	f.next.ServeHTTP(w, r)
+}
+
+// listen binds every -listen address, so that none is served unless all of
+// them could be bound. A Unix socket file left behind by a server that is no
+// longer running is replaced; the listener removes its file when closed.
+func listen(addresses []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		network, addr := flag.ListenNetwork(address)
+		if network == "unix" {
+			removeStaleSocket(addr)
+		}
+
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			for _, bound := range listeners {
+				bound.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenerName is the address of l as -listen would spell it.
+func listenerName(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return "unix:" + l.Addr().String()
+	}
+	return l.Addr().String()
+}
+
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		// Another server owns it; let net.Listen report the address in use.
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// serveMetrics serves endpoint at /metrics on address, in the background and
+// apart from the S3 API.
+func serveMetrics(address string, endpoint http.Handler) (*http.Server, error) {
+	listeners, err := listen([]string{address})
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", endpoint)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	fmt.Printf("Serving metrics on %s/metrics\n", listenerName(listeners[0]))
+	go func() {
+		if err := srv.Serve(listeners[0]); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics server failed: %v\n", err)
+		}
+	}()
+	return srv, nil
+}
+
+// closeMetrics lets scrapes in progress finish, so that none reads the
+// metadata once it is closed.
+func closeMetrics(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+	}
+}
+
+// serve runs srv on listeners until one fails or the process gets SIGINT or
+// SIGTERM. It then stops accepting connections and gives in-flight requests up to
+// drainTimeout to finish before closing their connections. When serve
+// returns no handler is running any more.
+//
+// With a certificate srv serves TLS, whose config must take its certificate
+// from certificate.GetCertificate, and SIGHUP reloads the certificate.
+func serve(srv *http.Server, listeners []net.Listener, drainTimeout time.Duration, certificate *certs.Reloader) error {
+	requests := &inFlight{next: srv.Handler}
+	srv.Handler = requests
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hangup := make(chan os.Signal, 1)
+	if certificate != nil {
+		signal.Notify(hangup, syscall.SIGHUP)
+		defer signal.Stop(hangup)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			if certificate != nil {
+				errs <- srv.ServeTLS(l, "", "")
+			} else {
+				errs <- srv.Serve(l)
+			}
+		}()
+	}
+
+	for done := false; !done; {
+		select {
+		case err := <-errs:
+			srv.Close()
+			requests.wg.Wait()
+			return err
+		case <-hangup:
+			if err := certificate.Reload(); err != nil {
+				log.Printf("Keeping the current TLS certificate: %v\n", err)
+			} else {
+				log.Printf("Reloaded TLS certificate\n")
+			}
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	// A second signal stops the process without waiting.
+	stop()
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests\n", drainTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("In-flight requests did not finish in time, closing their connections\n")
+		srv.Close()
+	}
+	requests.wg.Wait()
+	return nil
+}
@@ -0,0 +1,638 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"triple-s/locks"
+	"triple-s/utils"
+)
+
+// versionIDColumn is the position of the version ID in an object row.
+const versionIDColumn = 10
+
+// compactEvery is the number of journal entries after which the journal is
+// folded into the CSV snapshot and truncated.
+const compactEvery = 256
+
+// CSVStore keeps bucket metadata in <baseDir>/buckets.csv and object
+// metadata in <baseDir>/<bucket>/objects.csv. When opened with OpenCSVStore
+// a mutation is only appended to a write-ahead journal; the CSV files are
+// rewritten when the journal is compacted, and reads apply the entries still
+// pending on top of them.
+type CSVStore struct {
+	BaseDir string
+
+	journal *Journal
+	locks   *locks.Manager
+}
+
+func NewCSVStore(baseDir string) *CSVStore {
+	return &CSVStore{BaseDir: baseDir}
+}
+
+// OpenCSVStore returns a journaled CSVStore, replaying whatever the journal
+// holds from a previous run before returning.
+func OpenCSVStore(baseDir string, lockManager *locks.Manager) (*CSVStore, error) {
+	journal, err := OpenJournal(filepath.Join(baseDir, ".metadata.journal"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &CSVStore{BaseDir: baseDir, journal: journal, locks: lockManager}
+	if err := s.Compact(); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("failed to replay metadata journal: %v", err)
+	}
+	return s, nil
+}
+
+// Compact replays the journal into the CSV files and truncates it.
+func (s *CSVStore) Compact() error {
+	if s.journal == nil {
+		return nil
+	}
+
+	unlock, err := s.locks.LockJournal()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := s.journal.Entries()
+	if err != nil {
+		return err
+	}
+	changes, err := s.changesOf(entries)
+	if err != nil {
+		return err
+	}
+
+	// Each file is rewritten once, with all of its changes.
+	var paths []string
+	byPath := make(map[string][]change)
+	for _, c := range changes {
+		if _, ok := byPath[c.path]; !ok {
+			paths = append(paths, c.path)
+		}
+		byPath[c.path] = append(byPath[c.path], c)
+	}
+	for _, path := range paths {
+		if err := writeChanges(path, byPath[path]); err != nil {
+			return err
+		}
+	}
+	return s.journal.Truncate()
+}
+
+func (s *CSVStore) Close() error {
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.Compact(); err != nil {
+		return err
+	}
+	return s.journal.Close()
+}
+
+func (s *CSVStore) bucketsPath() string {
+	return filepath.Join(s.BaseDir, "buckets.csv")
+}
+
+func (s *CSVStore) objectsPath(bucket string) string {
+	return filepath.Join(s.BaseDir, bucket, "objects.csv")
+}
+
+func (s *CSVStore) versionsPath(bucket string) string {
+	return filepath.Join(s.BaseDir, bucket, "versions.csv")
+}
+
+func (s *CSVStore) ListBuckets() ([]Bucket, error) {
+	records, err := s.records(s.bucketsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]Bucket, 0, len(records))
+	for _, record := range records {
+		bucket, err := parseBucketRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func (s *CSVStore) GetBucket(name string) (Bucket, error) {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		return Bucket{}, err
+	}
+
+	for _, bucket := range buckets {
+		if bucket.Name == name {
+			return bucket, nil
+		}
+	}
+	return Bucket{}, ErrBucketNotFound
+}
+
+func (s *CSVStore) CreateBucket(bucket Bucket) error {
+	if err := utils.EnsureDirExists(s.BaseDir); err != nil {
+		return err
+	}
+
+	if _, err := s.GetBucket(bucket.Name); err == nil {
+		return ErrBucketExists
+	} else if !errors.Is(err, ErrBucketNotFound) {
+		return err
+	}
+
+	return s.commit(journalEntry{Op: opCreateBucket, Bucket: bucket.Name, Record: bucketRecord(bucket)})
+}
+
+func (s *CSVStore) UpdateBucket(name string, lastModified time.Time, status string) error {
+	bucket, err := s.GetBucket(name)
+	if err != nil {
+		return err
+	}
+
+	bucket.LastModifiedTime = lastModified
+	bucket.Status = status
+	return s.commit(journalEntry{Op: opUpdateBucket, Bucket: name, Record: bucketRecord(bucket)})
+}
+
+func (s *CSVStore) SaveBucket(bucket Bucket) error {
+	if _, err := s.GetBucket(bucket.Name); err != nil {
+		return err
+	}
+
+	return s.commit(journalEntry{Op: opUpdateBucket, Bucket: bucket.Name, Record: bucketRecord(bucket)})
+}
+
+func (s *CSVStore) DeleteBucket(name string) error {
+	if _, err := s.GetBucket(name); err != nil {
+		return err
+	}
+
+	return s.commit(journalEntry{Op: opDeleteBucket, Bucket: name})
+}
+
+func (s *CSVStore) ListObjects(bucket string) ([]Object, error) {
+	records, err := s.records(s.objectsPath(bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]Object, 0, len(records))
+	for _, record := range records {
+		object, err := ObjectFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (s *CSVStore) GetObject(bucket, key string) (Object, error) {
+	objects, err := s.ListObjects(bucket)
+	if err != nil {
+		return Object{}, err
+	}
+
+	for _, object := range objects {
+		if object.Key == key {
+			return object, nil
+		}
+	}
+	return Object{}, ErrObjectNotFound
+}
+
+func (s *CSVStore) PutObject(bucket string, object Object) error {
+	return s.commit(journalEntry{Op: opPutObject, Bucket: bucket, Key: object.Key, Record: ObjectToRecord(object)})
+}
+
+func (s *CSVStore) DeleteObject(bucket, key string) error {
+	if _, err := s.GetObject(bucket, key); err != nil {
+		return err
+	}
+
+	return s.commit(journalEntry{Op: opDeleteObject, Bucket: bucket, Key: key})
+}
+
+func (s *CSVStore) DeleteObjects(bucket string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.commit(journalEntry{Op: opDeleteObjects, Bucket: bucket, Keys: keys})
+}
+
+func (s *CSVStore) ListVersions(bucket string) ([]Object, error) {
+	records, err := s.records(s.versionsPath(bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Object, 0, len(records))
+	for _, record := range records {
+		version, err := ObjectFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (s *CSVStore) PutVersion(bucket string, version Object) error {
+	return s.commit(journalEntry{Op: opPutVersion, Bucket: bucket, Key: version.Key, VersionID: version.VersionID, Record: ObjectToRecord(version)})
+}
+
+func (s *CSVStore) DeleteVersion(bucket, key, versionID string) error {
+	versions, err := s.ListVersions(bucket)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, version := range versions {
+		if version.Key == key && version.VersionID == versionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrVersionNotFound
+	}
+
+	return s.commit(journalEntry{Op: opDeleteVersion, Bucket: bucket, Key: key, VersionID: versionID})
+}
+
+// commit makes a mutation durable in the journal. Without a journal it is
+// applied to the CSV files straight away.
+func (s *CSVStore) commit(entry journalEntry) error {
+	if s.journal == nil {
+		c, err := s.changeOf(entry)
+		if err != nil {
+			return err
+		}
+		return writeChanges(c.path, []change{c})
+	}
+
+	unlock, err := s.locks.RLockJournal()
+	if err != nil {
+		return err
+	}
+	err = s.journal.Append(entry)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.journal.Len() >= compactEvery {
+		// The mutation is already durable; a compaction that fails is
+		// retried on the next commit.
+		s.Compact()
+	}
+	return nil
+}
+
+// records returns the rows of a CSV file with the changes still pending in
+// the journal applied.
+func (s *CSVStore) records(path string) ([][]string, error) {
+	if s.journal == nil {
+		return readRecords(path)
+	}
+
+	unlock, err := s.locks.RLockJournal()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	records, err := readRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.journal.Entries()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.changesOf(entries)
+	if err != nil {
+		return nil, err
+	}
+	if !dirExists(path) {
+		return records, nil
+	}
+	records, _ = applyChanges(records, path, changes)
+	return records, nil
+}
+
+// change is what a journal entry does to the rows of one CSV file. Entries
+// only ever set or remove whole rows, so applying one again is harmless.
+type change struct {
+	path   string
+	update func(records [][]string) ([][]string, bool)
+}
+
+func (s *CSVStore) changeOf(entry journalEntry) (change, error) {
+	switch entry.Op {
+	case opCreateBucket, opUpdateBucket:
+		return change{s.bucketsPath(), upsertRow(matchKey(entry.Bucket), entry.Record)}, nil
+	case opDeleteBucket:
+		return change{s.bucketsPath(), removeRows(matchKey(entry.Bucket))}, nil
+	case opPutObject:
+		return change{s.objectsPath(entry.Bucket), upsertRow(matchKey(entry.Key), entry.Record)}, nil
+	case opDeleteObject:
+		return change{s.objectsPath(entry.Bucket), removeRows(matchKey(entry.Key))}, nil
+	case opDeleteObjects:
+		return change{s.objectsPath(entry.Bucket), removeRows(matchKeys(entry.Keys))}, nil
+	case opPutVersion:
+		return change{s.versionsPath(entry.Bucket), upsertRow(matchVersion(entry.Key, entry.VersionID), entry.Record)}, nil
+	case opDeleteVersion:
+		return change{s.versionsPath(entry.Bucket), removeRows(matchVersion(entry.Key, entry.VersionID))}, nil
+	default:
+		return change{}, fmt.Errorf("unknown journal operation %q", entry.Op)
+	}
+}
+
+// changesOf returns the changes of the journal entries in order. Entries
+// about a bucket that a later entry deletes are left out: its files went
+// with it, and a bucket created again under the same name starts empty.
+func (s *CSVStore) changesOf(entries []journalEntry) ([]change, error) {
+	lastDelete := make(map[string]int)
+	for i, entry := range entries {
+		if entry.Op == opDeleteBucket {
+			lastDelete[entry.Bucket] = i
+		}
+	}
+
+	changes := make([]change, 0, len(entries))
+	for i, entry := range entries {
+		if last, ok := lastDelete[entry.Bucket]; ok && i < last {
+			continue
+		}
+		c, err := s.changeOf(entry)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// applyChanges applies the changes to the file at path to its records and
+// reports whether any row changed.
+func applyChanges(records [][]string, path string, changes []change) ([][]string, bool) {
+	changed := false
+	for _, c := range changes {
+		if c.path != path {
+			continue
+		}
+		var ok bool
+		records, ok = c.update(records)
+		changed = changed || ok
+	}
+	return records, changed
+}
+
+// writeChanges rewrites the file at path with the changes applied. Files of
+// a bucket whose directory is gone are left alone.
+func writeChanges(path string, changes []change) error {
+	if !dirExists(path) {
+		return nil
+	}
+
+	records, err := readRecords(path)
+	if err != nil {
+		return err
+	}
+	records, changed := applyChanges(records, path, changes)
+	if !changed {
+		return nil
+	}
+	return utils.WriteCSVFile(path, records)
+}
+
+func dirExists(path string) bool {
+	_, err := os.Stat(filepath.Dir(path))
+	return !os.IsNotExist(err)
+}
+
+// matchKey selects rows by their first column.
+func matchKey(key string) func([]string) bool {
+	return func(record []string) bool {
+		return len(record) > 0 && record[0] == key
+	}
+}
+
+// matchKeys selects rows whose first column is any of keys.
+func matchKeys(keys []string) func([]string) bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return func(record []string) bool {
+		return len(record) > 0 && set[record[0]]
+	}
+}
+
+// matchVersion selects versions.csv rows by key and version ID.
+func matchVersion(key, versionID string) func([]string) bool {
+	return func(record []string) bool {
+		return len(record) > versionIDColumn && record[0] == key && record[versionIDColumn] == versionID
+	}
+}
+
+// upsertRow replaces the rows selected by match with record, or appends it
+// when there are none.
+func upsertRow(match func([]string) bool, record []string) func([][]string) ([][]string, bool) {
+	return func(records [][]string) ([][]string, bool) {
+		updatedRecords := make([][]string, 0, len(records)+1)
+		found := false
+		for _, existing := range records {
+			if match(existing) {
+				updatedRecords = append(updatedRecords, record)
+				found = true
+			} else {
+				updatedRecords = append(updatedRecords, existing)
+			}
+		}
+
+		if !found {
+			updatedRecords = append(updatedRecords, record)
+		}
+		return updatedRecords, true
+	}
+}
+
+func removeRows(match func([]string) bool) func([][]string) ([][]string, bool) {
+	return func(records [][]string) ([][]string, bool) {
+		updatedRecords := make([][]string, 0, len(records))
+		for _, existing := range records {
+			if !match(existing) {
+				updatedRecords = append(updatedRecords, existing)
+			}
+		}
+		return updatedRecords, len(updatedRecords) != len(records)
+	}
+}
+
+func readRecords(path string) ([][]string, error) {
+	records, err := utils.ReadCSVFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return records, nil
+}
+
+func bucketRecord(bucket Bucket) []string {
+	return []string{
+		bucket.Name,
+		bucket.CreationTime.Format(time.RFC3339),
+		bucket.LastModifiedTime.Format(time.RFC3339),
+		bucket.Status,
+		bucket.Versioning,
+		bucket.Policy,
+		bucket.ACL,
+	}
+}
+
+func parseBucketRecord(record []string) (Bucket, error) {
+	if len(record) < 4 {
+		return Bucket{}, fmt.Errorf("malformed bucket record: %v", record)
+	}
+
+	creationTime, err := time.Parse(time.RFC3339, record[1])
+	if err != nil {
+		return Bucket{}, fmt.Errorf("invalid creation time for bucket %q: %v", record[0], err)
+	}
+	lastModifiedTime, err := time.Parse(time.RFC3339, record[2])
+	if err != nil {
+		return Bucket{}, fmt.Errorf("invalid last modified time for bucket %q: %v", record[0], err)
+	}
+
+	bucket := Bucket{
+		Name:             record[0],
+		CreationTime:     creationTime,
+		LastModifiedTime: lastModifiedTime,
+		Status:           record[3],
+	}
+	if len(record) > 4 {
+		bucket.Versioning = record[4]
+	}
+	if len(record) > 5 {
+		bucket.Policy = record[5]
+	}
+	if len(record) > 6 {
+		bucket.ACL = record[6]
+	}
+	return bucket, nil
+}
+
+// ObjectToRecord encodes an object as an objects.csv row.
+func ObjectToRecord(object Object) []string {
+	return []string{
+		object.Key,
+		strconv.FormatInt(object.Size, 10),
+		object.ContentType,
+		object.LastModified.Format(time.RFC3339Nano),
+		object.ETag,
+		object.ContentEncoding,
+		object.ContentDisposition,
+		object.CacheControl,
+		object.Expires,
+		encodeUserMetadata(object.UserMetadata),
+		object.VersionID,
+		strconv.FormatBool(object.DeleteMarker),
+		object.ACL,
+	}
+}
+
+// ObjectFromRecord decodes an objects.csv row. Columns added after the
+// original four are optional so older rows still parse.
+func ObjectFromRecord(record []string) (Object, error) {
+	if len(record) < 4 {
+		return Object{}, fmt.Errorf("malformed object record: %v", record)
+	}
+
+	size, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return Object{}, fmt.Errorf("invalid size for object %q: %v", record[0], err)
+	}
+	lastModified, err := time.Parse(time.RFC3339, record[3])
+	if err != nil {
+		return Object{}, fmt.Errorf("invalid last modified time for object %q: %v", record[0], err)
+	}
+
+	object := Object{
+		Key:          record[0],
+		Size:         size,
+		ContentType:  record[2],
+		LastModified: lastModified,
+	}
+	optional := []*string{
+		&object.ETag,
+		&object.ContentEncoding,
+		&object.ContentDisposition,
+		&object.CacheControl,
+		&object.Expires,
+	}
+	for i, field := range optional {
+		if len(record) > 4+i {
+			*field = record[4+i]
+		}
+	}
+
+	if len(record) > 9 {
+		metadata, err := decodeUserMetadata(record[9])
+		if err != nil {
+			return Object{}, fmt.Errorf("invalid user metadata for object %q: %v", record[0], err)
+		}
+		object.UserMetadata = metadata
+	}
+
+	if len(record) > versionIDColumn {
+		object.VersionID = record[versionIDColumn]
+	}
+	if len(record) > versionIDColumn+1 {
+		object.DeleteMarker = record[versionIDColumn+1] == "true"
+	}
+	if len(record) > versionIDColumn+2 {
+		object.ACL = record[versionIDColumn+2]
+	}
+	return object, nil
+}
+
+// User metadata is kept in a single column as a URL-encoded query string.
+func encodeUserMetadata(metadata map[string]string) string {
+	values := url.Values{}
+	for name, value := range metadata {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+func decodeUserMetadata(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(values))
+	for name := range values {
+		metadata[name] = values.Get(name)
+	}
+	return metadata, nil
+}
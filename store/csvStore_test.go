@@ -0,0 +1,231 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"triple-s/locks"
+)
+
+const (
+	writers          = 16
+	writesPerWriter  = 25
+	stressBucketName = "stress"
+)
+
+func openTestStore(t *testing.T, dir string) (*CSVStore, *locks.Manager) {
+	t.Helper()
+
+	lockManager, err := locks.NewManager(filepath.Join(dir, ".locks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenCSVStore(dir, lockManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, lockManager
+}
+
+func createTestBucket(t *testing.T, s *CSVStore, name string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(s.BaseDir, name), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := s.CreateBucket(Bucket{Name: name, CreationTime: now, LastModifiedTime: now, Status: "Active"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putObjects has every writer put its own keys concurrently, holding the
+// bucket metadata lock the way ObjectHandler does.
+func putObjects(t *testing.T, stores []*CSVStore, managers []*locks.Manager) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writesPerWriter)
+	for w := 0; w < writers; w++ {
+		s, lockManager := stores[w%len(stores)], managers[w%len(managers)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				unlock, err := lockManager.LockBucketMetadata(stressBucketName)
+				if err != nil {
+					errs <- err
+					return
+				}
+				err = s.PutObject(stressBucketName, Object{Key: fmt.Sprintf("w%02d/%03d", w, i), Size: int64(i), LastModified: time.Now()})
+				unlock()
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func checkObjectCount(t *testing.T, s *CSVStore) {
+	t.Helper()
+
+	objects, err := s.ListObjects(stressBucketName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != writers*writesPerWriter {
+		t.Fatalf("got %d object rows, want %d", len(objects), writers*writesPerWriter)
+	}
+}
+
+func TestConcurrentPutObjectKeepsEveryRow(t *testing.T) {
+	dir := t.TempDir()
+	s, lockManager := openTestStore(t, dir)
+	createTestBucket(t, s, stressBucketName)
+
+	putObjects(t, []*CSVStore{s}, []*locks.Manager{lockManager})
+	checkObjectCount(t, s)
+
+	// The rows must also survive compaction and a restart.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, _ := openTestStore(t, dir)
+	defer reopened.Close()
+	checkObjectCount(t, reopened)
+}
+
+// Two stores over one directory with their own lock managers stand in for
+// two processes: only the file locks keep them apart.
+func TestConcurrentStoresSharingADirectoryKeepEveryRow(t *testing.T) {
+	dir := t.TempDir()
+	first, firstLocks := openTestStore(t, dir)
+	second, secondLocks := openTestStore(t, dir)
+	defer second.Close()
+	createTestBucket(t, first, stressBucketName)
+
+	putObjects(t, []*CSVStore{first, second}, []*locks.Manager{firstLocks, secondLocks})
+	checkObjectCount(t, first)
+	checkObjectCount(t, second)
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkObjectCount(t, second)
+}
+
+func TestConcurrentCreateBucketKeepsEveryRow(t *testing.T) {
+	s, lockManager := openTestStore(t, t.TempDir())
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockManager.LockMetadata()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer unlock()
+
+			now := time.Now()
+			errs <- s.CreateBucket(Bucket{Name: fmt.Sprintf("bucket-%02d", w), CreationTime: now, LastModifiedTime: now, Status: "Active"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != writers {
+		t.Fatalf("got %d bucket rows, want %d", len(buckets), writers)
+	}
+}
+
+func TestRecreatedBucketStartsEmpty(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestStore(t, dir)
+	createTestBucket(t, s, stressBucketName)
+
+	if err := s.PutObject(stressBucketName, Object{Key: "stale", LastModified: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBucket(stressBucketName); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, stressBucketName)); err != nil {
+		t.Fatal(err)
+	}
+	createTestBucket(t, s, stressBucketName)
+
+	check := func(s *CSVStore) {
+		t.Helper()
+		objects, err := s.ListObjects(stressBucketName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 0 {
+			t.Fatalf("got %d object rows in the recreated bucket, want none", len(objects))
+		}
+	}
+	check(s)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, _ := openTestStore(t, dir)
+	defer reopened.Close()
+	check(reopened)
+}
+
+// A process that crashes in the middle of an append leaves a torn line in
+// the journal; what others append after it must still count.
+func TestTornJournalLineKeepsLaterEntries(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestStore(t, dir)
+	defer s.Close()
+	createTestBucket(t, s, stressBucketName)
+
+	journal, err := os.OpenFile(filepath.Join(dir, ".metadata.journal"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = journal.WriteString("\n0badc0de {\"op\":\"put-obj")
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutObject(stressBucketName, Object{Key: "after", LastModified: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetObject(stressBucketName, "after"); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := openTestStore(t, dir)
+	defer other.Close()
+	if _, err := other.GetObject(stressBucketName, "after"); err != nil {
+		t.Fatal(err)
+	}
+}
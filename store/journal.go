@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	opCreateBucket  = "create-bucket"
+	opUpdateBucket  = "update-bucket"
+	opDeleteBucket  = "delete-bucket"
+	opPutObject     = "put-object"
+	opDeleteObject  = "delete-object"
+	opDeleteObjects = "delete-objects"
+	opPutVersion    = "put-version"
+	opDeleteVersion = "delete-version"
+)
+
+// journalEntry describes a single metadata mutation. Record holds the full
+// CSV row for create/update/put operations, so replaying an entry twice has
+// the same effect as replaying it once.
+type journalEntry struct {
+	Op        string   `json:"op"`
+	Bucket    string   `json:"bucket"`
+	Key       string   `json:"key,omitempty"`
+	VersionID string   `json:"versionId,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+	Record    []string `json:"record,omitempty"`
+}
+
+// Journal is an append-only log of metadata mutations. Each line is the
+// CRC-32 of the JSON payload followed by the payload itself. A crash in the
+// middle of an append leaves a line whose checksum does not match; it is
+// skipped, and as every append starts on a line of its own, the entries
+// other processes append after it are not lost with it.
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	file    *os.File
+	entries int
+}
+
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+func (j *Journal) Append(entry journalEntry) error {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("\n%08x %s", crc32.ChecksumIEEE(payload), payload)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.WriteString(line); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.entries++
+	return nil
+}
+
+// Entries returns every intact entry in the journal, skipping torn or
+// corrupted lines.
+func (j *Journal) Entries() ([]journalEntry, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		checksum, payload, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			continue
+		}
+
+		expected, err := strconv.ParseUint(string(checksum), 16, 32)
+		if err != nil || uint32(expected) != crc32.ChecksumIEEE(payload) {
+			continue
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Truncate empties the journal once its entries are reflected in the CSV
+// snapshot.
+func (j *Journal) Truncate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.entries = 0
+	return nil
+}
+
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.entries
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a MetadataStore that lives entirely in memory. It is meant
+// for tests and for running the server without touching the disk metadata.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	buckets  map[string]Bucket
+	objects  map[string]map[string]Object
+	versions map[string][]Object
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]Bucket),
+		objects:  make(map[string]map[string]Object),
+		versions: make(map[string][]Object),
+	}
+}
+
+func (s *MemoryStore) ListBuckets() ([]Bucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buckets := make([]Bucket, 0, len(s.buckets))
+	for _, bucket := range s.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].CreationTime.Before(buckets[j].CreationTime)
+	})
+	return buckets, nil
+}
+
+func (s *MemoryStore) GetBucket(name string) (Bucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bucket, ok := s.buckets[name]
+	if !ok {
+		return Bucket{}, ErrBucketNotFound
+	}
+	return bucket, nil
+}
+
+func (s *MemoryStore) CreateBucket(bucket Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket.Name]; ok {
+		return ErrBucketExists
+	}
+	s.buckets[bucket.Name] = bucket
+	s.objects[bucket.Name] = make(map[string]Object)
+	return nil
+}
+
+func (s *MemoryStore) UpdateBucket(name string, lastModified time.Time, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[name]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	bucket.LastModifiedTime = lastModified
+	bucket.Status = status
+	s.buckets[name] = bucket
+	return nil
+}
+
+func (s *MemoryStore) SaveBucket(bucket Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket.Name]; !ok {
+		return ErrBucketNotFound
+	}
+	s.buckets[bucket.Name] = bucket
+	return nil
+}
+
+func (s *MemoryStore) DeleteBucket(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[name]; !ok {
+		return ErrBucketNotFound
+	}
+	delete(s.buckets, name)
+	delete(s.objects, name)
+	delete(s.versions, name)
+	return nil
+}
+
+func (s *MemoryStore) ListObjects(bucket string) ([]Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects := make([]Object, 0, len(s.objects[bucket]))
+	for _, object := range s.objects[bucket] {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+func (s *MemoryStore) GetObject(bucket, key string) (Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[bucket][key]
+	if !ok {
+		return Object{}, ErrObjectNotFound
+	}
+	return object, nil
+}
+
+func (s *MemoryStore) PutObject(bucket string, object Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		return ErrBucketNotFound
+	}
+	s.objects[bucket][object.Key] = object
+	return nil
+}
+
+func (s *MemoryStore) DeleteObject(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[bucket][key]; !ok {
+		return ErrObjectNotFound
+	}
+	delete(s.objects[bucket], key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteObjects(bucket string, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.objects[bucket], key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListVersions(bucket string) ([]Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Object(nil), s.versions[bucket]...), nil
+}
+
+func (s *MemoryStore) PutVersion(bucket string, version Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		return ErrBucketNotFound
+	}
+
+	for i, existing := range s.versions[bucket] {
+		if existing.Key == version.Key && existing.VersionID == version.VersionID {
+			s.versions[bucket][i] = version
+			return nil
+		}
+	}
+	s.versions[bucket] = append(s.versions[bucket], version)
+	return nil
+}
+
+func (s *MemoryStore) DeleteVersion(bucket, key, versionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, existing := range s.versions[bucket] {
+		if existing.Key == key && existing.VersionID == versionID {
+			s.versions[bucket] = append(s.versions[bucket][:i], s.versions[bucket][i+1:]...)
+			return nil
+		}
+	}
+	return ErrVersionNotFound
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrBucketNotFound  = errors.New("bucket not found")
+	ErrBucketExists    = errors.New("bucket already exists")
+	ErrObjectNotFound  = errors.New("object not found")
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+type Bucket struct {
+	Name             string
+	CreationTime     time.Time
+	LastModifiedTime time.Time
+	Status           string
+
+	// Versioning is empty for buckets that never had versioning enabled,
+	// otherwise VersioningEnabled or VersioningSuspended.
+	Versioning string
+
+	// Policy is the bucket policy JSON document, empty when none is set.
+	Policy string
+
+	// ACL is the bucket's canned ACL; empty means private.
+	ACL string
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	ETag         string
+
+	// Representation headers supplied at upload time and returned on GET and
+	// HEAD. An empty ContentType means the client did not send one.
+	ContentEncoding    string
+	ContentDisposition string
+	CacheControl       string
+	Expires            string
+
+	// UserMetadata holds the x-amz-meta-* headers, keyed by the lower-case
+	// name without the prefix.
+	UserMetadata map[string]string
+
+	// VersionID is empty for objects written while the bucket was never
+	// versioned; S3 reports those as the "null" version.
+	VersionID    string
+	DeleteMarker bool
+
+	// ACL is the object's canned ACL; empty means private.
+	ACL string
+}
+
+// MetadataStore keeps track of bucket and object metadata. Handlers talk to
+// it instead of touching buckets.csv and objects.csv directly, so the storage
+// backend can be swapped without changing any HTTP code.
+type MetadataStore interface {
+	ListBuckets() ([]Bucket, error)
+	GetBucket(name string) (Bucket, error)
+	CreateBucket(bucket Bucket) error
+	UpdateBucket(name string, lastModified time.Time, status string) error
+	SaveBucket(bucket Bucket) error
+	DeleteBucket(name string) error
+
+	ListObjects(bucket string) ([]Object, error)
+	GetObject(bucket, key string) (Object, error)
+	PutObject(bucket string, object Object) error
+	DeleteObject(bucket, key string) error
+	// DeleteObjects removes several keys in one metadata update. Keys that
+	// do not exist are ignored.
+	DeleteObjects(bucket string, keys []string) error
+
+	// Versions holds the noncurrent versions and delete markers of a
+	// versioned bucket; the current version of a key lives with the objects.
+	ListVersions(bucket string) ([]Object, error)
+	PutVersion(bucket string, version Object) error
+	DeleteVersion(bucket, key, versionID string) error
+}
@@ -1,9 +1,7 @@
 package utils
 
 import (
-	"encoding/csv"
 	"errors"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -51,25 +49,3 @@ func isIPAddress(name string) bool {
 
 	return true
 }
-
-func CheckBucketUniqueness(name, csvPath string) (bool, error) {
-	file, err := os.Open(csvPath)
-	if err != nil {
-		return false, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return false, err
-	}
-
-	for _, record := range records {
-		if len(record) > 0 && record[0] == name {
-			return false, nil
-		}
-	}
-
-	return true, nil
-}
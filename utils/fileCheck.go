@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"os"
 )
 
@@ -14,3 +15,38 @@ func EnsureFileExists(filePath string) error {
 	}
 	return nil
 }
+
+// WriteTempFile streams r into a new temporary file in dir and fsyncs it.
+// The caller is responsible for renaming or removing the returned path.
+func WriteTempFile(dir, pattern string, r io.Reader) (string, int64, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", 0, err
+	}
+	tempPath := file.Name()
+
+	size, err := io.Copy(file, r)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", 0, err
+	}
+
+	return tempPath, size, nil
+}
+
+// SyncDir flushes directory entries so that a rename survives a crash.
+func SyncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ReservedPrefix marks files and directories the server keeps inside a
+// bucket directory. Keys may not use it, so server state is never mistaken
+// for an object.
+const ReservedPrefix = ".triple-s-"
+
+const (
+	// TempUploadPrefix marks in-progress uploads.
+	TempUploadPrefix = ReservedPrefix + "upload-"
+	// MultipartDir holds the staged parts of multipart uploads.
+	MultipartDir = ReservedPrefix + "multipart"
+	// VersionsDir holds the data of noncurrent object versions.
+	VersionsDir = ReservedPrefix + "versions"
+)
+
+// maxFileName is the longest file name common file systems accept.
+const maxFileName = 255
+
+var fileNameEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// ObjectFileName is the name of the file holding an object's data in its
+// bucket directory. Every key maps to a single file, so "a" and "a/b" can
+// both exist and no directories are left behind when a key is deleted.
+// Keys are escaped, and hashed when the escaped name would be too long.
+func ObjectFileName(key string) string {
+	name := fileNameEscaper.Replace(key)
+	if len(name) > maxFileName {
+		sum := sha256.Sum256([]byte(key))
+		return ReservedPrefix + "key-" + hex.EncodeToString(sum[:])
+	}
+	return name
+}
+
+var ErrKeyTooLong = errors.New("object key must not be longer than 1024 bytes")
+
+func ValidateObjectKey(key string) error {
+	if key == "" {
+		return errors.New("object key must not be empty")
+	}
+
+	if len(key) > 1024 {
+		return ErrKeyTooLong
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.New("object key must not contain empty, '.' or '..' path segments")
+		}
+		if strings.HasPrefix(segment, ReservedPrefix) {
+			return errors.New("object key must not use the reserved '" + ReservedPrefix + "' prefix")
+		}
+	}
+
+	if key == "objects.csv" || key == "versions.csv" {
+		return errors.New("object key '" + key + "' is reserved for metadata")
+	}
+
+	return nil
+}
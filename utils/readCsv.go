@@ -3,6 +3,7 @@ package utils
 import (
 	"encoding/csv"
 	"os"
+	"path/filepath"
 )
 
 func ReadCSVFile(filename string) ([][]string, error) {
@@ -13,18 +14,43 @@ func ReadCSVFile(filename string) ([][]string, error) {
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
 	return reader.ReadAll()
 }
 
+// WriteCSVFile replaces filename atomically: the records are written to a
+// temporary file next to it which is then renamed over the original, so
+// readers never observe a half-written file.
 func WriteCSVFile(filename string, data [][]string) error {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tempPath := tempFile.Name()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	writer := csv.NewWriter(tempFile)
+	if err := writer.WriteAll(data); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Chmod(tempPath, 0o644); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
 
-	return writer.WriteAll(data)
+	if err := os.Rename(tempPath, filename); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
 }